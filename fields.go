@@ -0,0 +1,113 @@
+package logcap
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/onsi/gomega/format"
+	"github.com/onsi/gomega/matchers"
+	"github.com/onsi/gomega/types"
+)
+
+// The matchers in this file are meant for use as values in a
+// logrus.Fields{} argument to HaveLogs(), though they work anywhere a
+// Gomega matcher does.
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+type uuidMatcher struct{}
+
+// ValidUUID matches a field value that is a UUID in the usual
+// 8-4-4-4-12 hex form. The value may be a string or anything with a
+// String() method that renders that way (such as most UUID types):
+//
+//   HaveLogs("request started", logrus.Fields{"request_id": logcap.ValidUUID})
+var ValidUUID types.GomegaMatcher = uuidMatcher{}
+
+func (uuidMatcher) Match(actual interface{}) (success bool, err error) {
+	switch v := actual.(type) {
+	case string:
+		return uuidRegexp.MatchString(v), nil
+	case fmt.Stringer:
+		return uuidRegexp.MatchString(v.String()), nil
+	}
+	return false, nil
+}
+
+func (uuidMatcher) FailureMessage(actual interface{}) (message string) {
+	return format.Message(actual, "to be a valid UUID")
+}
+
+func (uuidMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return format.Message(actual, "not to be a valid UUID")
+}
+
+// AllRegexp matches a string field value that matches every one of
+// the given regular expressions. This is handy when a composite value
+// has to satisfy several format rules at once:
+//
+//   HaveLogs("order placed", logrus.Fields{"order_id": logcap.AllRegexp(`^ORD-`, `-\d{6}$`)})
+//
+// The failure message names the first pattern that didn't match.
+func AllRegexp(patterns ...string) types.GomegaMatcher {
+	var regexps []types.GomegaMatcher
+	for _, pattern := range patterns {
+		regexps = append(regexps, &matchers.MatchRegexpMatcher{Regexp: pattern})
+	}
+	return &matchers.AndMatcher{Matchers: regexps}
+}
+
+type floatWithinMatcher struct {
+	expected, tol float64
+}
+
+// FloatWithin matches a numeric field value within tol of expected,
+// inclusive. The value may be any integer or float type, or a string
+// holding a number (as some formatters and wrappers leave them), so
+// metrics can be checked without brittle exact comparisons:
+//
+//   HaveLogs("batch done", logrus.Fields{"seconds": logcap.FloatWithin(1.5, 0.01)})
+func FloatWithin(expected, tol float64) types.GomegaMatcher {
+	return &floatWithinMatcher{expected: expected, tol: tol}
+}
+
+// toFloat converts a numeric value, or a string holding one, to a
+// float64.
+func toFloat(actual interface{}) (float64, bool) {
+	if s, ok := actual.(fmt.Stringer); ok {
+		actual = s.String() // json.Number and the like
+	}
+	v := reflect.ValueOf(actual)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func (m *floatWithinMatcher) Match(actual interface{}) (success bool, err error) {
+	f, ok := toFloat(actual)
+	if !ok {
+		return false, nil
+	}
+	return math.Abs(f-m.expected) <= m.tol, nil
+}
+
+func (m *floatWithinMatcher) FailureMessage(actual interface{}) (message string) {
+	return format.Message(actual, fmt.Sprintf("to be a number within %v of %v", m.tol, m.expected))
+}
+
+func (m *floatWithinMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return format.Message(actual, fmt.Sprintf("not to be a number within %v of %v", m.tol, m.expected))
+}
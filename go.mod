@@ -5,5 +5,9 @@ go 1.14
 require (
 	github.com/onsi/ginkgo v1.14.1
 	github.com/onsi/gomega v1.10.2
+	github.com/rs/zerolog v1.20.0
 	github.com/sirupsen/logrus v1.7.0
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/zap v1.16.0
 )
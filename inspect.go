@@ -0,0 +1,231 @@
+package logcap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Record is a captured log entry laid out for inspection.
+type Record struct {
+	Message string
+	Level   logrus.Level
+	Time    time.Time
+	Fields  logrus.Fields // As logged, without the file and line keys
+	Source  string        // file:line of the call site, if known
+}
+
+// EntriesWithSource returns everything captured so far, matched or
+// not, as Records in the order it was logged. Nothing is consumed, so
+// HaveLogs() and HaveNoLogs() see the same entries afterwards.
+func (hook *LogCap) EntriesWithSource() []Record {
+	var records []Record
+	for _, entry := range hook.snapshot() {
+		source := ""
+		if file, ok := entry.Data["file"]; ok {
+			source = fmt.Sprintf("%s:%d", file, entry.Data["line"])
+		}
+		records = append(records, Record{
+			Message: entry.Message,
+			Level:   entry.Level,
+			Time:    entry.Time,
+			Fields:  appFields(entry.Data),
+			Source:  source,
+		})
+	}
+	return records
+}
+
+// Entries drains everything logged so far into the hook's cache and
+// returns the captured entries, matched or not, in the order they were
+// logged, for assertions the matchers don't cover:
+//
+//   for _, entry := range logHook.Entries() {
+//   	Ω(entry.Data).Should(HaveKey("request_id"))
+//   }
+//
+// The slice is a fresh copy but the entries are the hook's own, so
+// don't modify them. Nothing is consumed or marked as matched: later
+// HaveLogs() and HaveNoLogs() calls still see every entry.
+func (hook *LogCap) Entries() []*logrus.Entry {
+	var entries []*logrus.Entry
+	for _, entry := range hook.snapshot() {
+		entries = append(entries, entry.Entry)
+	}
+	return entries
+}
+
+// CaptureSpan returns the time between the first and the last entry
+// captured so far, going by the entries' Time. It is zero if fewer
+// than two entries have been captured.
+func (hook *LogCap) CaptureSpan() time.Duration {
+	entries := hook.snapshot()
+	if len(entries) < 2 {
+		return 0
+	}
+	return entries[len(entries)-1].Time.Sub(entries[0].Time)
+}
+
+// Diff compares the messages captured so far, in the order they were
+// logged, with an expected list and returns a unified-diff style
+// report: lines only in expected start with "-", captured lines that
+// weren't expected start with "+", and shared lines with a space. It
+// returns "" when they're the same. Like a snapshot test, this gives
+// a readable picture of what went wrong with a long sequence.
+func (hook *LogCap) Diff(expected []string) string {
+	var captured []string
+	for _, entry := range hook.snapshot() {
+		captured = append(captured, entry.Message)
+	}
+	return diffLines(expected, captured)
+}
+
+// diffLines diffs two lists of lines using their longest common
+// subsequence.
+func diffLines(a, b []string) string {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	same := true
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+a[i])
+			same = false
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			same = false
+			j++
+		}
+	}
+	if same {
+		return ""
+	}
+	return "--- expected\n+++ captured\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// EntriesWithField returns every entry captured so far, matched or
+// not, whose key field has the given value, in the order logged. The
+// value is compared the same way as in a logrus.Fields{} argument to
+// HaveLogs(), so it may also be a Gomega matcher. This makes it easy
+// to pick out the logs of one service when several log through
+// entries derived with WithField():
+//
+//   apiLogs := logHook.EntriesWithField("svc", "api")
+func (hook *LogCap) EntriesWithField(key string, value interface{}) []*logrus.Entry {
+	match := &logsMatch{Fields: &logrus.Fields{key: value}}
+	var entries []*logrus.Entry
+	for _, entry := range hook.snapshot() {
+		if ok, err := match.fieldsMatch(entry.Data); err == nil && ok {
+			entries = append(entries, entry.Entry)
+		}
+	}
+	return entries
+}
+
+// ByLevel drains everything logged so far into the hook's cache and
+// returns the captured entries, matched or not, grouped by level.
+// Within each level the entries are in the order they were logged.
+// This lets a test make separate assertions about, say, the warnings
+// and the errors. Nothing is consumed: HaveLogs() and HaveNoLogs()
+// still see the same entries afterwards.
+func (hook *LogCap) ByLevel() map[logrus.Level][]*logrus.Entry {
+	levels := map[logrus.Level][]*logrus.Entry{}
+	for _, entry := range hook.snapshot() {
+		levels[entry.Level] = append(levels[entry.Level], entry.Entry)
+	}
+	return levels
+}
+
+// EntriesByGoroutine drains everything logged so far into the hook's
+// cache and returns the captured entries, matched or not, grouped by
+// the ID of the goroutine that logged them. Each goroutine's entries
+// are in the order it logged them, however the goroutines interleaved,
+// so each worker's sequence can be checked on its own:
+//
+//   for _, entries := range logHook.EntriesByGoroutine() {
+//   	Ω(entries[0].Message).Should(Equal("worker started"))
+//   }
+//
+// Nothing is consumed.
+func (hook *LogCap) EntriesByGoroutine() map[int64][]*logrus.Entry {
+	routines := map[int64][]*logrus.Entry{}
+	for _, entry := range hook.snapshot() {
+		routines[entry.routine] = append(routines[entry.routine], entry.Entry)
+	}
+	return routines
+}
+
+// Count drains everything logged so far into the hook's cache and
+// returns how many entries have been captured. It counts every entry,
+// whether or not a matcher has already matched it, so it's the number
+// of logs emitted since the hook was created or last Reset():
+//
+//   Ω(logHook.Count()).Should(Equal(5))
+func (hook *LogCap) Count() int {
+	return len(hook.snapshot())
+}
+
+// CountAtLevel works like Count() but only counts entries at the given
+// level.
+func (hook *LogCap) CountAtLevel(level logrus.Level) (count int) {
+	for _, entry := range hook.snapshot() {
+		if entry.Level == level {
+			count++
+		}
+	}
+	return
+}
+
+// Fingerprint returns a hash of everything captured so far, matched or
+// not, as a hex string. It covers each entry's level, message and
+// fields (keys sorted, values formatted with %v) in the order they
+// were logged. The time and the file and line of the call site are
+// left out, so the same logs give the same fingerprint from run to run
+// and from one version of the code to the next. Values that format
+// differently each run (pointers, say) will spoil that. Compare with a
+// golden value using MatchFingerprint().
+func (hook *LogCap) Fingerprint() string {
+	h := sha256.New()
+	for _, entry := range hook.snapshot() {
+		fmt.Fprintf(h, "%s %q", entry.Level, entry.Message)
+		fields := appFields(entry.Data)
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, " %q=%q", k, fmt.Sprint(fields[k]))
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -33,26 +33,59 @@ It's designed to work within Ginkgo test suites:
 package logcap
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"github.com/onsi/gomega/types"
 	"github.com/sirupsen/logrus"
 )
 
 // Logcap is the base type that implements a Logrus hook.
 type LogCap struct {
-	oldOut   io.Writer
-	entries  chan *logrus.Entry
+	oldOuts  []io.Writer // One per logger, saved by Start()
+	entries  chan *markedEntry
 	ignores  []string
-	logger   *logrus.Logger
+	logger   *logrus.Logger // The first of the loggers
+	loggers  []*logrus.Logger
 	display  map[logrus.Level]interface{}
+	redact   map[string]bool
+	valueFor map[string]types.GomegaMatcher // From RegisterFieldMatcher()
 	cache    []*markedEntry
 	cacheMut sync.Mutex
+	seq      uint64
+	seqMut   sync.Mutex
+	highMark int // Most entries ever waiting in the channel
+
+	finalLevel    bool
+	pending       map[*logrus.Entry]*markedEntry // Held back by CaptureFinalLevel()
+	pendingMut    sync.Mutex
+	captureStack  bool
+	keepHooks     bool
+	tagger        func(*logrus.Entry) map[string]interface{}
+	displayWhere  func(*logrus.Entry) bool
+	names         map[*logrus.Logger]string
+	ignoreRegexps []*regexp.Regexp
+	maxFieldBytes int
+	onCollision   KeyCollisionPolicy
+	spans         map[string]*span
+	tees          []logrus.Hook
+	teed          int
+	teeMut        sync.Mutex
+	written       int64         // Bytes the logger wrote while capturing
+	blockFor      time.Duration // How long to wait for room in the buffer
 }
 
 // Display registers log levels to display to os.Stderr. Normally, all
@@ -64,6 +97,48 @@ func (hook *LogCap) Display(levels ...logrus.Level) {
 	}
 }
 
+// DisplayFrom displays logs at level or more severe to os.Stderr, so
+// DisplayFrom(logrus.InfoLevel) shows info, warning, error, fatal and
+// panic logs. It's shorthand for Display() with each of those levels,
+// and combines with Display() and DisplayTo() the same way.
+func (hook *LogCap) DisplayFrom(level logrus.Level) {
+	for _, l := range logrus.AllLevels {
+		if l <= level {
+			hook.display[l] = true
+		}
+	}
+}
+
+// DisplayTo works like Display() but prints logs for the given levels
+// to w instead of os.Stderr. This makes it easy for a test to look at
+// what was displayed:
+//
+//   var buf bytes.Buffer
+//   logHook.DisplayTo(&buf, logrus.WarnLevel, logrus.ErrorLevel)
+//
+// A level can only be displayed in one place; the last call for a
+// level wins.
+func (hook *LogCap) DisplayTo(w io.Writer, levels ...logrus.Level) {
+	for _, level := range levels {
+		hook.display[level] = w
+	}
+}
+
+// DisplayWhere prints logs to os.Stderr when fn returns true for them,
+// whatever their level, so one subsystem can be watched in a noisy
+// suite:
+//
+//   logHook.DisplayWhere(func(e *logrus.Entry) bool {
+//   	return e.Data["component"] == "auth"
+//   })
+//
+// Logs at levels given to Display() or DisplayTo() are still printed
+// there as well. fn sees the entry as logged, without the file and
+// line fields.
+func (hook *LogCap) DisplayWhere(fn func(*logrus.Entry) bool) {
+	hook.displayWhere = fn
+}
+
 // IgnoreCaller registers filenames (or parts of filenames) that
 // shouldn't be included when tracing the call stack back to find the
 // file and line number to display with log failures. It defaults to
@@ -74,97 +149,1065 @@ func (hook *LogCap) IgnoreCaller(s string) {
 	hook.ignores = append(hook.ignores, s)
 }
 
+// IgnoreCallerRegexp works like IgnoreCaller() but ignores the files
+// whose full path matches a regular expression, for when a substring
+// would catch too much:
+//
+//   err := logHook.IgnoreCallerRegexp(`_gen\.go$`)
+//
+// It returns an error, and ignores nothing, if the pattern doesn't
+// compile.
+func (hook *LogCap) IgnoreCallerRegexp(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	hook.ignoreRegexps = append(hook.ignoreRegexps, re)
+	return nil
+}
+
+// RedactFields registers field keys whose values are sensitive. Their
+// values are replaced with "***" wherever fields are printed in
+// failure messages, so secrets don't end up in CI logs. Matching
+// still sees the real values.
+func (hook *LogCap) RedactFields(keys ...string) {
+	for _, key := range keys {
+		hook.redact[key] = true
+	}
+}
+
+// RegisterFieldMatcher registers a matcher for the values of a field
+// key, so expectations that only ask for the key don't have to repeat
+// its format each time:
+//
+//   logHook.RegisterFieldMatcher("request_id", logcap.ValidUUID)
+//   Ω(logHook).Should(HaveLogs("request started", HaveFieldKeys("request_id")))
+//
+// The matcher applies wherever HaveFieldKeys() requires the key. A
+// value given for the same key in a logrus.Fields{} argument takes
+// precedence over it, and a key named with HaveLogsIgnoringFields() is
+// left alone. Registering a key again replaces its matcher.
+func (hook *LogCap) RegisterFieldMatcher(key string, matcher types.GomegaMatcher) {
+	if hook.valueFor == nil {
+		hook.valueFor = map[string]types.GomegaMatcher{}
+	}
+	hook.valueFor[key] = matcher
+}
+
+// redacted returns a copy of data with redacted values masked.
+func (hook *LogCap) redacted(data logrus.Fields) logrus.Fields {
+	fields := logrus.Fields{}
+	for k, v := range data {
+		if hook.redact[k] {
+			v = "***"
+		}
+		fields[k] = v
+	}
+	return fields
+}
+
+// appFields returns a copy of the fields of a captured entry as the
+// application logged them, without the file and line keys added by
+// logcap.
+func appFields(data logrus.Fields) logrus.Fields {
+	fields := logrus.Fields{}
+	for k, v := range data {
+		if k != "file" && k != "line" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// userFields returns the application's fields of a captured entry
+// ready for printing.
+func (hook *LogCap) userFields(data logrus.Fields) logrus.Fields {
+	return hook.redacted(appFields(data))
+}
+
+// TagEntries registers a function that works out extra fields for
+// each entry as it's captured. The fields it returns are added to the
+// captured entry (not the one being logged), so matchers can check
+// metadata derived at test time:
+//
+//   logHook.TagEntries(func(e *logrus.Entry) map[string]interface{} {
+//   	return map[string]interface{}{"slow": e.Data["ms"].(int) > 100}
+//   })
+//   Ω(logHook).Should(HaveLogs("query", logrus.Fields{"slow": true}))
+//
+// Tags never overwrite fields the application logged (or the file and
+// line of the call site), so give them their own keys. Register the
+// function before logging starts.
+func (hook *LogCap) TagEntries(fn func(*logrus.Entry) map[string]interface{}) {
+	hook.tagger = fn
+}
+
+// CaptureFinalLevel makes the hook record the level an entry has at
+// the end of the hook chain rather than the level it had when this
+// hook fired. Logrus runs hooks in the order they were added, so a
+// hook added after Start() that changes entry.Level (escalating
+// repeated warnings to errors, say) would otherwise go unnoticed.
+//
+// To see the end of the chain, the hook wraps each logger's Formatter,
+// which Logrus calls once every hook has fired. Entries are held back
+// until then, so matchers never see them early. Stop() puts the
+// formatter back. If the logger's formatter is replaced while
+// capturing, entries are recorded with the level they had when this
+// hook fired.
+func (hook *LogCap) CaptureFinalLevel() {
+	hookMutex.Lock()
+	defer hookMutex.Unlock()
+	hook.finalLevel = true
+	hook.wrapFormatters()
+}
+
+// finalFormatter wraps a logger's Formatter to release the entries
+// CaptureFinalLevel() holds back once the hook chain has run.
+type finalFormatter struct {
+	logrus.Formatter
+	hook *LogCap
+}
+
+func (f *finalFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	if err := f.hook.release(e); err != nil {
+		return nil, err
+	}
+	return f.Formatter.Format(e)
+}
+
+// wrapFormatters puts a finalFormatter around each logger's Formatter
+// that doesn't have one. The caller must hold hookMutex.
+func (hook *LogCap) wrapFormatters() {
+	for _, logger := range hook.loggers {
+		if !hook.formats(logger) {
+			logger.Formatter = &finalFormatter{Formatter: logger.Formatter, hook: hook}
+		}
+	}
+}
+
+// unwrapFormatters removes the finalFormatters wrapFormatters() added,
+// leaving alone any formatter set since. The caller must hold
+// hookMutex.
+func (hook *LogCap) unwrapFormatters() {
+	for _, logger := range hook.loggers {
+		if f, ok := logger.Formatter.(*finalFormatter); ok && f.hook == hook {
+			logger.Formatter = f.Formatter
+		}
+	}
+}
+
+// formats reports whether this hook's finalFormatter is the logger's
+// Formatter.
+func (hook *LogCap) formats(logger *logrus.Logger) bool {
+	f, ok := logger.Formatter.(*finalFormatter)
+	return ok && f.hook == hook
+}
+
+// hold keeps a captured entry back until its logger formats it.
+func (hook *LogCap) hold(e *logrus.Entry, entry *markedEntry) {
+	hook.pendingMut.Lock()
+	defer hook.pendingMut.Unlock()
+	if hook.pending == nil {
+		hook.pending = map[*logrus.Entry]*markedEntry{}
+	}
+	hook.pending[e] = entry
+}
+
+// release queues the entry held back for e, if there is one, with the
+// level e has now.
+func (hook *LogCap) release(e *logrus.Entry) error {
+	hook.pendingMut.Lock()
+	entry, ok := hook.pending[e]
+	delete(hook.pending, e)
+	hook.pendingMut.Unlock()
+	if !ok {
+		return nil
+	}
+	entry.Level = e.Level
+	return hook.queue(entry)
+}
+
+// releaseAll queues every entry still held back, for when Stop()
+// removes the formatters that would have released them.
+func (hook *LogCap) releaseAll() {
+	hook.pendingMut.Lock()
+	var held []*logrus.Entry
+	for e := range hook.pending {
+		held = append(held, e)
+	}
+	hook.pendingMut.Unlock()
+	for _, e := range held {
+		hook.release(e)
+	}
+}
+
 var outMutex sync.Mutex
 
-// Fire is required to implement the Logrus hook interface
+// Fire is required to implement the Logrus hook interface. When the
+// logger has SetReportCaller(true), the call site Logrus found is used
+// (and its function shown in failure messages) unless it's in a file
+// ignored with IgnoreCaller().
 func (hook *LogCap) Fire(e *logrus.Entry) error {
+	var (
+		file string
+		line int
+	)
+	if e.HasCaller() && !hook.ignored(e.Caller.File) {
+		// Logrus already found the call site with SetReportCaller().
+		file, line = e.Caller.File, e.Caller.Line
+	} else {
+		file, line = hook.callSite()
+	}
+	outMutex.Lock()
+	e.Logger.Out = ioutil.Discard
+	if dest, ok := hook.display[e.Level]; ok {
+		var out io.Writer = os.Stderr
+		if w, ok := dest.(io.Writer); ok {
+			out = w
+		}
+		e.Logger.Out = &countingWriter{w: out, n: &hook.written}
+	} else if hook.displayWhere != nil && hook.displayWhere(e) {
+		e.Logger.Out = &countingWriter{w: os.Stderr, n: &hook.written}
+	}
+	outMutex.Unlock()
+	var stack []string
+	if hook.captureStack {
+		stack = hook.stack()
+	}
+	if hook.finalLevel && hook.formats(e.Logger) {
+		marked, err := hook.mark(e, file, line, stack)
+		if err != nil {
+			return err
+		}
+		hook.hold(e, marked)
+		return hook.tee(e)
+	}
+	return hook.capture(e, file, line, stack)
+}
+
+// callSite walks up from Fire() to the first caller that isn't
+// ignored.
+func (hook *LogCap) callSite() (file string, line int) {
+	for i := 2; ; i++ { // Skip callSite and Fire
+		_, f, l, ok := runtime.Caller(i)
+		if !ok {
+			return
+		}
+		if !hook.ignored(f) {
+			return f, l
+		}
+	}
+}
+
+// ignored reports whether a file was registered with IgnoreCaller()
+// or IgnoreCallerRegexp().
+func (hook *LogCap) ignored(file string) bool {
+	for _, substring := range hook.ignores {
+		if strings.Contains(file, substring) {
+			return true
+		}
+	}
+	for _, re := range hook.ignoreRegexps {
+		if re.MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// stack returns the call stack of the logging call, leaving out
+// ignored callers and the runtime, one "function file:line" per frame.
+func (hook *LogCap) stack() (stack []string) {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !hook.ignored(frame.File) && !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// goroutineID returns the ID of the calling goroutine, read from the
+// header of its stack trace ("goroutine 7 [running]:").
+func goroutineID() int64 {
+	buf := make([]byte, 64)
+	fields := strings.Fields(string(buf[:runtime.Stack(buf, false)]))
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseInt(fields[1], 10, 64)
+	return id
+}
+
+// capture copies an entry, records where it was logged and queues it
+// for the matchers. It runs on the logging goroutine.
+func (hook *LogCap) capture(e *logrus.Entry, file string, line int, stack []string) error {
+	marked, err := hook.mark(e, file, line, stack)
+	if err != nil {
+		return err
+	}
+	if err := hook.queue(marked); err != nil {
+		return err
+	}
+	return hook.tee(e)
+}
+
+// mark copies an entry and records where it was logged.
+func (hook *LogCap) mark(e *logrus.Entry, file string, line int, stack []string) (*markedEntry, error) {
 	entry := logrus.Entry{
 		Logger:  e.Logger,
 		Time:    e.Time,
 		Level:   e.Level,
 		Message: e.Message,
 		Buffer:  e.Buffer,
+		Context: e.Context,
 		Data:    logrus.Fields{},
 	}
 	// Copy data into new struct
 	for k, v := range e.Data {
-		entry.Data[k] = v
+		entry.Data[k] = hook.truncate(v)
 	}
-
-EntryLoop:
-	for i := 1; ; i++ {
-		if _, file, line, ok := runtime.Caller(i); ok {
-			for _, substring := range hook.ignores {
-				if strings.Contains(file, substring) {
-					continue EntryLoop
-				}
+	if file != "" {
+		if err := hook.setCaller(entry.Data, file, line); err != nil {
+			return nil, err
+		}
+	}
+	if name, ok := hook.names[e.Logger]; ok {
+		if _, taken := entry.Data["logger"]; !taken {
+			entry.Data["logger"] = name
+		}
+	}
+	if hook.tagger != nil {
+		for k, v := range hook.tagger(&entry) {
+			if _, ok := entry.Data[k]; !ok {
+				entry.Data[k] = v
 			}
-			entry.Data["file"] = file
-			entry.Data["line"] = line
 		}
-		break
 	}
-	outMutex.Lock()
-	e.Logger.Out = ioutil.Discard
-	if _, ok := hook.display[entry.Level]; ok {
-		e.Logger.Out = os.Stderr
+	marked := &markedEntry{Entry: &entry, stack: stack, routine: goroutineID()}
+	if e.HasCaller() && !hook.ignored(e.Caller.File) {
+		marked.caller = e.Caller.Function
 	}
-	outMutex.Unlock()
+	return marked, nil
+}
+
+// queue numbers a captured entry and hands it to the channel.
+// Numbering and queueing happen together so that the channel, and so
+// the cache, is always in sequence order.
+func (hook *LogCap) queue(entry *markedEntry) error {
+	hook.seqMut.Lock()
+	defer hook.seqMut.Unlock()
+	entry.seq = hook.seq + 1
 	select {
-	case hook.entries <- &entry:
+	case hook.entries <- entry:
 	default:
-		return errors.New("internal buffer full, use a higher entryCount value")
+		if hook.blockFor <= 0 {
+			return errors.New("internal buffer full, use a higher entryCount value")
+		}
+		timer := time.NewTimer(hook.blockFor)
+		defer timer.Stop()
+		select {
+		case hook.entries <- entry:
+		case <-timer.C:
+			return fmt.Errorf("internal buffer full for %s, use a higher entryCount value", hook.blockFor)
+		}
+	}
+	hook.seq++
+	if n := len(hook.entries); n > hook.highMark {
+		hook.highMark = n
+	}
+	return nil
+}
+
+// tee passes a captured entry on to the hooks given with Tee(). The
+// entry is only counted as teed if every hook that wants its level
+// took it without error.
+func (hook *LogCap) tee(e *logrus.Entry) error {
+	if len(hook.tees) == 0 {
+		return nil
+	}
+	for _, t := range hook.tees {
+		for _, level := range t.Levels() {
+			if level == e.Level {
+				if err := t.Fire(e); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	hook.teeMut.Lock()
+	hook.teed++
+	hook.teeMut.Unlock()
+	return nil
+}
+
+// TeeCount returns how many captured entries were passed on to the
+// hooks given with Tee(). If the downstream hooks didn't drop
+// anything, it's the same as the number of entries captured.
+func (hook *LogCap) TeeCount() int {
+	hook.teeMut.Lock()
+	defer hook.teeMut.Unlock()
+	return hook.teed
+}
+
+// setCaller records the call site in an entry's fields, following
+// the hook's KeyCollisionPolicy if the application already used the
+// keys.
+func (hook *LogCap) setCaller(data logrus.Fields, file string, line int) error {
+	_, hasFile := data["file"]
+	_, hasLine := data["line"]
+	if hasFile || hasLine {
+		switch hook.onCollision {
+		case CollisionSkip:
+			return nil
+		case CollisionError:
+			key := "file"
+			if !hasFile {
+				key = "line"
+			}
+			return fmt.Errorf("entry already has a %q field", key)
+		}
+	}
+	if !hasFile {
+		data["file"] = file
+	}
+	if !hasLine {
+		data["line"] = line
 	}
 	return nil
 }
 
+// Sequence returns the sequence number the hook gave a captured
+// entry. Entries are numbered from 1 in the order they were captured,
+// so the numbers give a well-defined order even when timestamps
+// collide. Matchers that care about order use this order. The second
+// return value is false if the entry isn't one captured by this hook.
+func (hook *LogCap) Sequence(entry *logrus.Entry) (uint64, bool) {
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
+	hook.drain()
+	for _, e := range hook.cache {
+		if e.Entry == entry {
+			return e.seq, true
+		}
+	}
+	return 0, false
+}
+
+// HighWaterMark returns the largest number of entries that have been
+// waiting in the hook's internal buffer at once. Entries leave the
+// buffer when a matcher looks at them, so this is how close the logs
+// logged between matchers came to the entryCount given to
+// NewLogHook(). Reset() doesn't clear it.
+func (hook *LogCap) HighWaterMark() int {
+	hook.seqMut.Lock()
+	defer hook.seqMut.Unlock()
+	return hook.highMark
+}
+
+// A span is a named stretch of the capture, bounded by the sequence
+// numbers current at Begin() and End().
+type span struct {
+	begin, end uint64
+	ended      bool
+}
+
+// contains reports whether an entry was logged within the span.
+func (s span) contains(entry *markedEntry) bool {
+	return entry.seq > s.begin && (!s.ended || entry.seq <= s.end)
+}
+
+// Begin opens a named span. Together with End(), it marks off the
+// logs of one part of a test (a database transaction, say) for
+// HaveLogsBetween(). Calling Begin() again with the same name starts
+// the span afresh.
+func (hook *LogCap) Begin(name string) {
+	hook.seqMut.Lock()
+	defer hook.seqMut.Unlock()
+	hook.spans[name] = &span{begin: hook.seq}
+}
+
+// End closes a named span opened by Begin(). Entries logged after
+// End() aren't part of the span. Ending a span that was never begun
+// does nothing.
+func (hook *LogCap) End(name string) {
+	hook.seqMut.Lock()
+	defer hook.seqMut.Unlock()
+	if s, ok := hook.spans[name]; ok {
+		s.end, s.ended = hook.seq, true
+	}
+}
+
+// findSpan returns a copy of a named span.
+func (hook *LogCap) findSpan(name string) (span, bool) {
+	hook.seqMut.Lock()
+	defer hook.seqMut.Unlock()
+	s, ok := hook.spans[name]
+	if !ok {
+		return span{}, false
+	}
+	return *s, true
+}
+
 // Levels is required to implement the Logrus hook interface
 func (hook *LogCap) Levels() []logrus.Level {
 	return logrus.AllLevels
 }
 
+// drain moves any entries waiting in the channel into the cache
+// without blocking. The caller must hold cacheMut.
+func (hook *LogCap) drain() {
+	for {
+		select {
+		case entry := <-hook.entries:
+			hook.store(entry)
+		default:
+			return
+		}
+	}
+}
+
+// Reset throws away everything captured so far, matched or not,
+// without detaching the hook. This splits a test into phases: do
+// phase one, assert its logs, Reset(), then do phase two. It's safe to
+// call while other goroutines are logging; entries they log after the
+// reset are kept.
+func (hook *LogCap) Reset() {
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
+	hook.drain()
+	hook.cache = nil
+}
+
+// snapshot drains the channel and returns a copy of everything
+// captured so far, matched or not, in the order it was logged.
+func (hook *LogCap) snapshot() []*markedEntry {
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
+	hook.drain()
+	return append([]*markedEntry(nil), hook.cache...)
+}
+
+// fill blocks until the cache holds at least n entries, giving up
+// when deadline fires. It reports whether there are enough entries.
+// The caller must hold cacheMut.
+func (hook *LogCap) fill(n int, deadline <-chan time.Time) bool {
+	for len(hook.cache) < n {
+		select {
+		case entry := <-hook.entries:
+			hook.store(entry)
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+// store adds an entry taken from the channel to the cache. The caller
+// must hold cacheMut.
+func (hook *LogCap) store(entry *markedEntry) {
+	hook.cache = append(hook.cache, entry)
+}
+
+// pollInterval is how often background waiters look for new entries.
+const pollInterval = time.Millisecond * 10
+
+// AwaitLog returns a channel that receives the first captured entry
+// whose message matches m (a string or a Gomega matcher). This is
+// handy for coordinating goroutines around log events:
+//
+//   <-logHook.AwaitLog("worker ready")
+//
+// The entry is left in place so later HaveLogs() calls can still
+// match it. An optional time.Duration sets how long to wait (the
+// default timeout, see SetDefaultTimeout) and an optional
+// context.Context cancels the wait early. If nothing matches in
+// time, the channel is closed without a value.
+func (hook *LogCap) AwaitLog(m interface{}, args ...interface{}) <-chan *logrus.Entry {
+	expected := matcherOrEqual(m).Expected
+	timeout := defaultTimeout()
+	ctx := context.Background()
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case time.Duration:
+			timeout = a
+		case context.Context:
+			ctx = a
+		}
+	}
+
+	found := make(chan *logrus.Entry, 1)
+	go func() {
+		defer close(found)
+		deadline := time.After(timeout)
+		seen := 0
+		for {
+			hook.cacheMut.Lock()
+			hook.drain()
+			for ; seen < len(hook.cache); seen++ {
+				entry := hook.cache[seen]
+				if ok, err := expected.Match(entry.Message); err == nil && ok {
+					hook.cacheMut.Unlock()
+					found <- entry.Entry
+					return
+				}
+			}
+			hook.cacheMut.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-deadline:
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+	}()
+	return found
+}
+
+// WaitForN waits for n captured entries whose message matches m (a
+// string or a Gomega matcher) and returns them in the order they were
+// logged. This counts the runs of periodic code without racing it:
+//
+//   ticks, err := logHook.WaitForN("tick", 3, time.Second)
+//   Ω(err).ShouldNot(HaveOccurred())
+//
+// The entries are left in place so later HaveLogs() calls can still
+// match them. If fewer than n turn up within the timeout, it returns
+// the ones it found along with an error.
+func (hook *LogCap) WaitForN(m interface{}, n int, timeout time.Duration) ([]*logrus.Entry, error) {
+	expected := matcherOrEqual(m).Expected
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
+	var found []*logrus.Entry
+	deadline := time.After(timeout)
+	for seen := 0; len(found) < n; seen++ {
+		if seen == len(hook.cache) {
+			select {
+			case entry := <-hook.entries:
+				hook.store(entry)
+			case <-deadline:
+				return found, fmt.Errorf("found %d of %d logs matching %s", len(found), n, describe(m))
+			}
+		}
+		entry := hook.cache[seen]
+		ok, err := expected.Match(entry.Message)
+		if err != nil {
+			return found, err
+		}
+		if ok {
+			found = append(found, entry.Entry)
+		}
+	}
+	return found, nil
+}
+
+// WaitFor blocks until an entry whose message satisfies the matcher
+// is captured, for flow control rather than assertions:
+//
+//   go server.Run()
+//   Ω(logHook.WaitFor(ContainSubstring("listening"), time.Second)).Should(Succeed())
+//
+// Like WaitForN(), it leaves every entry in place for later
+// assertions. It returns an error if nothing matches within the
+// timeout.
+func (hook *LogCap) WaitFor(matcher types.GomegaMatcher, timeout time.Duration) error {
+	_, err := hook.WaitForN(matcher, 1, timeout)
+	return err
+}
+
+// exitSignal is what the ExitFunc installed by CatchExit() panics
+// with to unwind out of a Fatal log.
+type exitSignal int
+
+// exitTrap is the ExitFunc CatchExit() installs.
+func exitTrap(code int) {
+	panic(exitSignal(code))
+}
+
+// trapped reports whether a logger's ExitFunc is still the one
+// CatchExit() installed, so it's only put back if nobody replaced it
+// since.
+func trapped(logger *logrus.Logger) bool {
+	return logger.ExitFunc != nil && reflect.ValueOf(logger.ExitFunc).Pointer() == reflect.ValueOf(exitTrap).Pointer()
+}
+
+// CatchExit runs fn with the hook's logger set up so that Fatal logs
+// (and anything else that calls the logger's Exit) end fn instead of
+// the test binary. It reports the exit code and whether fn exited:
+//
+//   code, exited := logHook.CatchExit(func() { server.Shutdown() })
+//   Ω(exited).Should(BeTrue())
+//   Ω(code).Should(Equal(1))
+//   Ω(logHook).Should(HaveLogs("shutting down", logrus.FatalLevel))
+//
+// Nothing after the Fatal call in fn runs. Only exits on the calling
+// goroutine are caught. Panic logs already panic rather than exit, so
+// they're checked with Gomega's Panic() matcher instead:
+//
+//   Ω(func() { logrus.Panic("corrupt state") }).Should(Panic())
+//   Ω(logHook).Should(HaveLogs("corrupt state", logrus.PanicLevel))
+func (hook *LogCap) CatchExit(fn func()) (code int, exited bool) {
+	exitFuncs := make([]func(int), len(hook.loggers))
+	for i, logger := range hook.loggers {
+		exitFuncs[i] = logger.ExitFunc
+		logger.ExitFunc = exitTrap
+	}
+	defer func() {
+		for i, logger := range hook.loggers {
+			if trapped(logger) {
+				logger.ExitFunc = exitFuncs[i]
+			}
+		}
+		if r := recover(); r != nil {
+			signal, ok := r.(exitSignal)
+			if !ok {
+				panic(r)
+			}
+			code, exited = int(signal), true
+		}
+	}()
+	fn()
+	return
+}
+
 var hookMutex sync.Mutex
 
-// Start starts the hook, attaching it to the given logger.
+// Start starts the hook, attaching it to the given loggers.
 func (hook *LogCap) Start() {
 	hookMutex.Lock()
 	defer hookMutex.Unlock()
-	hook.logger.Hooks.Add(hook)
-	hook.oldOut = hook.logger.Out
+	atomic.StoreInt64(&hook.written, 0)
+	hook.oldOuts = make([]io.Writer, len(hook.loggers))
+	for i, logger := range hook.loggers {
+		logger.Hooks.Add(hook)
+		hook.oldOuts[i] = logger.Out
+		// Anything that gets past Fire() lands here and is counted.
+		logger.Out = &countingWriter{w: logger.Out, n: &hook.written}
+	}
+	if hook.finalLevel {
+		hook.wrapFormatters()
+	}
+}
+
+// countingWriter adds up the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// OutputBytes returns how many bytes the hook's loggers have written
+// to real output, their original Out or a Display() destination, since
+// Start().
+// Without Display() this should stay zero, so a test can check that
+// nothing leaked past the capture:
+//
+//   Ω(logHook.OutputBytes()).Should(BeZero())
+func (hook *LogCap) OutputBytes() int {
+	return int(atomic.LoadInt64(&hook.written))
 }
 
-// Stop stops the hook and removes ALL hooks from the logger.
+// Stop stops the hook and removes ALL hooks from its loggers, or just
+// this one with PreserveHooks(). Each logger's output is put back the
+// way it was when the hook started.
+//
+// Capturing doesn't replace a logger's ExitFunc, so a Fatal log calls
+// the application's own ExitFunc (os.Exit by default) as usual; use
+// CatchExit() to keep it from ending the test. Stop() leaves the
+// ExitFunc alone.
 func (hook *LogCap) Stop() {
 	hookMutex.Lock()
 	defer hookMutex.Unlock()
-	hook.logger.Out = hook.oldOut
-	hook.logger.Hooks = make(logrus.LevelHooks) // Remove any hooks
+	hook.releaseAll()
+	hook.unwrapFormatters()
+	for i, logger := range hook.loggers {
+		if i < len(hook.oldOuts) {
+			logger.Out = hook.oldOuts[i]
+		}
+		if !hook.keepHooks {
+			logger.Hooks = make(logrus.LevelHooks) // Remove any hooks
+			continue
+		}
+		hooks := make(logrus.LevelHooks)
+		for level, levelHooks := range logger.Hooks {
+			for _, h := range levelHooks {
+				if h != logrus.Hook(hook) {
+					hooks[level] = append(hooks[level], h)
+				}
+			}
+		}
+		logger.Hooks = hooks
+	}
+}
+
+// SaveLogrusState records the level, output, formatter and hooks of
+// the given loggers (or of logrus.StandardLogger() if none are given)
+// and returns a function that puts them back. Deferring it keeps a
+// test's changes from leaking into the rest of the suite, even when a
+// panic skips the AfterEach():
+//
+//   defer logcap.SaveLogrusState()()
+//   logrus.SetLevel(logrus.TraceLevel)
+//   logHook := NewLogHook() // Removes every hook until restored
+//   logHook.Start()
+//
+// Restoring brings back any hooks NewLogHook() or Stop() removed.
+func SaveLogrusState(loggers ...*logrus.Logger) (restore func()) {
+	if len(loggers) == 0 {
+		loggers = []*logrus.Logger{logrus.StandardLogger()}
+	}
+	type state struct {
+		level     logrus.Level
+		out       io.Writer
+		formatter logrus.Formatter
+		hooks     logrus.LevelHooks
+	}
+	states := make([]state, len(loggers))
+	for i, logger := range loggers {
+		hooks := make(logrus.LevelHooks)
+		for level, levelHooks := range logger.Hooks {
+			hooks[level] = append([]logrus.Hook(nil), levelHooks...)
+		}
+		states[i] = state{logger.GetLevel(), logger.Out, logger.Formatter, hooks}
+	}
+	return func() {
+		hookMutex.Lock()
+		defer hookMutex.Unlock()
+		for i, logger := range loggers {
+			logger.SetLevel(states[i].level)
+			logger.SetOutput(states[i].out)
+			logger.SetFormatter(states[i].formatter)
+			logger.ReplaceHooks(states[i].hooks)
+		}
+	}
 }
 
-// NewLogHook creates a new LogCap hook. If one of the supplied
-// arguments is a *logrus.Logger, it'll attach the hook to that
-// logger. Otherwise it'll attach to the logrus.StandardLogger(). If
+// NewLogHook creates a new LogCap hook. If any of the supplied
+// arguments are *logrus.Logger, it'll attach the hook to each of
+// those loggers. Otherwise it'll attach to the logrus.StandardLogger().
+// Entries from all the loggers go into the same capture, in the order
+// they were logged, and match the same way. Unless the loggers are
+// named with NameLogger(), nothing records which logger an entry came
+// from, so identical logs from two loggers can't be told apart. If
 // one of the supplied arguments is an int, it will be used as the
 // entryCount, the number of logs that can be held in the internal
-// buffer. If that limit is reached, logrus will error.
+// buffer. If that limit is reached, logrus will error. Any Option
+// arguments are applied to the new hook.
 func NewLogHook(args ...interface{}) *LogCap {
-	logger := logrus.StandardLogger()
+	var loggers []*logrus.Logger
 	entryCount := 1000
+	var options []Option
 
+ArgLoop:
 	for _, arg := range args {
 		switch a := arg.(type) {
 		case *logrus.Logger:
-			logger = a
+			for _, logger := range loggers {
+				if logger == a {
+					continue ArgLoop
+				}
+			}
+			loggers = append(loggers, a)
 		case int:
 			entryCount = a
+		case Option:
+			options = append(options, a)
 		}
 	}
 
-	logger.Hooks = make(logrus.LevelHooks)
-	return &LogCap{
-		logger:  logger,
-		entries: make(chan *logrus.Entry, entryCount),
+	if len(loggers) == 0 {
+		loggers = []*logrus.Logger{logrus.StandardLogger()}
+	}
+
+	hook := &LogCap{
+		logger:  loggers[0],
+		loggers: loggers,
+		entries: make(chan *markedEntry, entryCount),
 		display: make(map[logrus.Level]interface{}),
+		redact:  make(map[string]bool),
+		spans:   make(map[string]*span),
 		ignores: []string{"sirupsen/logrus"}, // trim Logrus callers from chain
 	}
+	for _, option := range options {
+		option(hook)
+	}
+	if !hook.keepHooks {
+		for _, logger := range loggers {
+			logger.Hooks = make(logrus.LevelHooks)
+		}
+	}
+	return hook
+}
+
+// An Option configures a hook. Pass options to NewLogHook().
+type Option func(*LogCap)
+
+// KeyCollisionPolicy says what the hook does when an application logs
+// its own "file" or "line" field, clashing with the keys the hook uses
+// to record the call site. See OnKeyCollision().
+type KeyCollisionPolicy int
+
+// The policies are prefixed so they don't clash with Ginkgo's Skip()
+// when both packages are dot-imported.
+const (
+	// CollisionPrefer keeps the application's value for a key it
+	// set and records the call site under the other. Failure
+	// messages then show the application's value as the call site.
+	// This is the default.
+	CollisionPrefer KeyCollisionPolicy = iota
+	// CollisionSkip keeps the application's fields as they are and
+	// records no call site at all when either key is taken.
+	CollisionSkip
+	// CollisionError makes the hook refuse the entry. Logrus
+	// reports the error on stderr and the entry isn't captured.
+	CollisionError
+)
+
+// OnKeyCollision sets what the hook does when an entry already has a
+// "file" or "line" field:
+//
+//   logHook := NewLogHook(logcap.OnKeyCollision(logcap.CollisionError))
+func OnKeyCollision(policy KeyCollisionPolicy) Option {
+	return func(hook *LogCap) {
+		hook.onCollision = policy
+	}
+}
+
+// Tee passes every entry the hook captures on to other hooks as
+// well. Stop() removes all of a logger's hooks, so a pipeline that
+// needs its own hooks to see the logs during a test can chain them
+// here instead:
+//
+//   logHook := NewLogHook(logcap.Tee(shippingHook))
+//
+// Entries the hook fails to capture (when its buffer is full, say)
+// aren't passed on. TeeCount() says how many were.
+func Tee(hooks ...logrus.Hook) Option {
+	return func(hook *LogCap) {
+		hook.tees = append(hook.tees, hooks...)
+	}
+}
+
+// PreserveHooks leaves the logger's other hooks alone. Normally
+// NewLogHook() and Stop() remove every hook from the logger, which
+// quietly disables an application's own hooks (error reporting, say)
+// during and after the test. With this option the hook adds itself
+// alongside them and Stop() removes only this hook:
+//
+//   logHook := NewLogHook(logcap.PreserveHooks())
+func PreserveHooks() Option {
+	return func(hook *LogCap) {
+		hook.keepHooks = true
+	}
+}
+
+// CaptureStack makes the hook record the whole call stack of each
+// entry, not just the file and line of the call site. Failure messages
+// then show where unexpected logs came from, which helps track down
+// flaky assertions. Callers excluded with IgnoreCaller() are left out
+// of the stack too. Walking the stack for every log is slow, so it's
+// off by default:
+//
+//   logHook := NewLogHook(logcap.CaptureStack())
+func CaptureStack() Option {
+	return func(hook *LogCap) {
+		hook.captureStack = true
+	}
+}
+
+// NameLogger has the hook record name in a "logger" field of every
+// entry captured from logger, so tests watching several loggers can
+// tell their logs apart:
+//
+//   logHook := NewLogHook(apiLogger, dbLogger,
+//   	logcap.NameLogger(apiLogger, "api"), logcap.NameLogger(dbLogger, "db"))
+//   ...
+//   Ω(logHook).Should(HaveLogs("query failed", logrus.Fields{"logger": "db"}))
+//
+// The field shows up in failure messages too. An application field
+// already called "logger" is left alone. Entries captured through
+// SlogHandler() or ZapCore() count as coming from the hook's first
+// logger.
+func NameLogger(logger *logrus.Logger, name string) Option {
+	return func(hook *LogCap) {
+		if hook.names == nil {
+			hook.names = map[*logrus.Logger]string{}
+		}
+		hook.names[logger] = name
+	}
+}
+
+// BlockOnFull makes logging wait up to timeout for room when the
+// internal buffer is full, instead of failing at once. Entries only
+// leave the buffer while a matcher is looking at them, so this helps
+// when bursts of logs race an assertion that's waiting for them:
+//
+//   logHook := NewLogHook(100, logcap.BlockOnFull(time.Second))
+//
+// The logging goroutine is held up meanwhile, along with any other
+// goroutine logging through the hook. If there's still no room after
+// the timeout, the entry is dropped with an error as usual.
+func BlockOnFull(timeout time.Duration) Option {
+	return func(hook *LogCap) {
+		hook.blockFor = timeout
+	}
+}
+
+// truncatedMarker is appended to field values cut short by
+// MaxFieldBytes().
+const truncatedMarker = "...[truncated]"
+
+// MaxFieldBytes limits how much of each string (or []byte) field
+// value the hook keeps. Longer values are cut to at most n bytes,
+// short of any UTF-8 character that would be split, and get
+// "...[truncated]" appended. This bounds memory when the code under
+// test might log enormous values:
+//
+//   logHook := NewLogHook(logcap.MaxFieldBytes(1024))
+//
+// Matchers see the truncated value, so expectations for long fields
+// need to allow for the cut (a MatchRegexp or HavePrefix matcher, for
+// instance). Other types of values are kept as they are.
+func MaxFieldBytes(n int) Option {
+	return func(hook *LogCap) {
+		hook.maxFieldBytes = n
+	}
+}
+
+// truncate cuts a field value down to the hook's MaxFieldBytes().
+func (hook *LogCap) truncate(v interface{}) interface{} {
+	if hook.maxFieldBytes <= 0 {
+		return v
+	}
+	switch v := v.(type) {
+	case string:
+		if len(v) > hook.maxFieldBytes {
+			return runePrefix(v, hook.maxFieldBytes) + truncatedMarker
+		}
+	case []byte:
+		if len(v) > hook.maxFieldBytes {
+			return runePrefix(string(v), hook.maxFieldBytes) + truncatedMarker
+		}
+	}
+	return v
+}
+
+// runePrefix returns at most n bytes from the start of s, backing off
+// so as not to split a UTF-8 character.
+func runePrefix(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
 }
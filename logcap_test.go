@@ -1,12 +1,18 @@
 package logcap
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 	. "github.com/onsi/ginkgo"
@@ -76,6 +82,16 @@ var _ = Describe("LogCap", func() {
 			Ω(h.FailureMessage(logHook)).ShouldNot(ContainSubstring(`logcap_test.go`))
 			Ω(logHook).Should(HaveLogs("I need some pancakes", time.Millisecond*100))
 		})
+		It("ignores call sites matching a regexp", func() {
+			Ω(logHook.IgnoreCallerRegexp(`[`)).ShouldNot(Succeed())
+			Ω(logHook.IgnoreCallerRegexp(`/logcap_test\.gox$`)).Should(Succeed())
+			logrus.Info("still here")
+			Ω(logHook.EntriesWithSource()[0].Source).Should(ContainSubstring("logcap_test.go"))
+			Ω(logHook.IgnoreCallerRegexp(`/logcap_test\.go$`)).Should(Succeed())
+			logrus.Info("elsewhere")
+			Ω(logHook.EntriesWithSource()[1].Source).ShouldNot(ContainSubstring("logcap_test.go"))
+			Ω(logHook).Should(HaveLogs("still here", "elsewhere"))
+		})
 		It("composes with Gomega matchers", func() {
 			logrus.Warning("This is a number: 23984329 yeah")
 			Ω(logHook).Should(HaveLogs(MatchRegexp(`number: \d+ yeah`)))
@@ -105,6 +121,1345 @@ var _ = Describe("LogCap", func() {
 			Ω(h.NegatedFailureMessage(logHook)).Should(ContainSubstring("This is a warning"))
 			Ω(logHook).Should(HaveLogs("This is a warning."))
 		})
+		It("matches within a level range", func() {
+			logrus.Info("in range")
+			logrus.Warning("also in range")
+			Ω(logHook).Should(HaveLogsAtLevelRange(logrus.InfoLevel, logrus.WarnLevel, "in range", "also in range"))
+		})
+		It("accepts level range bounds in either order", func() {
+			logrus.Warning("in range")
+			Ω(logHook).Should(HaveLogsAtLevelRange(logrus.WarnLevel, logrus.InfoLevel, "in range"))
+		})
+		It("ignores entries outside the level range", func() {
+			logrus.Debug("too chatty")
+			logrus.Error("too loud")
+			Ω(logHook).ShouldNot(HaveLogsAtLevelRange(logrus.InfoLevel, logrus.WarnLevel, "too chatty", time.Millisecond*100))
+			Ω(logHook).ShouldNot(HaveLogsAtLevelRange(logrus.InfoLevel, logrus.WarnLevel, "too loud", time.Millisecond*100))
+			Ω(logHook).Should(HaveLogs("too chatty", "too loud"))
+		})
+		It("matches field keys regardless of value", func() {
+			logrus.WithFields(logrus.Fields{"method": "GET", "path": "/"}).Info("request handled")
+			Ω(logHook).Should(HaveLogs("request handled", HaveFieldKeys("method", "path")))
+		})
+		It("lists missing field keys on failure", func() {
+			logrus.WithFields(logrus.Fields{"method": "GET"}).Info("request handled")
+			h := HaveLogs("request handled", HaveFieldKeys("method", "path"), time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("is missing keys [path]"))
+			Ω(logHook).Should(HaveLogs("request handled", HaveFieldKeys("method")))
+		})
+		It("redacts sensitive fields in failure messages", func() {
+			logHook.RedactFields("password")
+			logrus.WithFields(logrus.Fields{"user": "bob", "password": "hunter2"}).Info("logged in")
+			h := HaveLogs("logged out", time.Millisecond*100)
+			h.Match(logHook)
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`\"password\":\"***\"`))
+			Ω(h.FailureMessage(logHook)).ShouldNot(ContainSubstring("hunter2"))
+			n := HaveNoLogs()
+			n.Match(logHook)
+			Ω(n.FailureMessage(logHook)).Should(ContainSubstring("password:***"))
+			Ω(n.FailureMessage(logHook)).ShouldNot(ContainSubstring("hunter2"))
+			Ω(logHook).Should(HaveLogs("logged in", logrus.Fields{"password": "hunter2"}))
+		})
+		It("records the level as seen by this hook by default", func() {
+			logrus.AddHook(escalateHook{})
+			logrus.Warning("escalated")
+			Ω(logHook).ShouldNot(HaveLogsAtLevelRange(logrus.ErrorLevel, logrus.ErrorLevel, "escalated", time.Millisecond*100))
+			Ω(logHook).Should(HaveLogsAtLevelRange(logrus.WarnLevel, logrus.WarnLevel, "escalated"))
+		})
+		It("records the final level with CaptureFinalLevel", func() {
+			logHook.CaptureFinalLevel()
+			logrus.AddHook(escalateHook{})
+			logrus.Warning("escalated")
+			Ω(logHook).Should(HaveLogsAtLevelRange(logrus.ErrorLevel, logrus.ErrorLevel, "escalated"))
+		})
+		It("holds entries back for CaptureFinalLevel while a matcher waits", func() {
+			logHook.CaptureFinalLevel()
+			logrus.AddHook(slowEscalateHook{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				time.Sleep(time.Millisecond * 20)
+				logrus.Warning("escalated")
+			}()
+			Ω(logHook).Should(HaveLogsAtLevelRange(logrus.ErrorLevel, logrus.ErrorLevel, "escalated"))
+			<-done
+		})
+		It("checks a handshake sequence then allows anything", func() {
+			logrus.Info("hello")
+			logrus.Info("auth ok")
+			logrus.Info("session 12")
+			logrus.Info("traffic")
+			logrus.Warning("more traffic")
+			Ω(logHook.ExpectSequenceThenAny([]interface{}{"hello", "auth ok", MatchRegexp(`session \d+`)})).Should(Succeed())
+		})
+		It("errors on an out of order handshake", func() {
+			logrus.Info("auth ok")
+			logrus.Info("hello")
+			err := logHook.ExpectSequenceThenAny([]interface{}{"hello", "auth ok"})
+			Ω(err).Should(MatchError(ContainSubstring("log 0 of sequence out of place")))
+			Ω(err.Error()).Should(ContainSubstring("logcap_test.go"))
+			Ω(logHook).Should(HaveLogs("hello", "auth ok"))
+		})
+		It("gives a handshake one overall timeout", func() {
+			defer SetDefaultTimeout(defaultTimeout())
+			SetDefaultTimeout(time.Millisecond * 100)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for _, message := range []string{"hello", "auth ok", "session 1"} {
+					time.Sleep(time.Millisecond * 60)
+					logrus.Info(message)
+				}
+			}()
+			err := logHook.ExpectSequenceThenAny([]interface{}{"hello", "auth ok", "session 1"})
+			Ω(err).Should(MatchError(HaveSuffix("of 3 sequence logs were captured")))
+			<-done
+			Ω(logHook).Should(HaveLogs("hello", "auth ok", "session 1"))
+		})
+		It("errors on a short handshake", func() {
+			logrus.Info("hello")
+			Ω(logHook.ExpectSequenceThenAny([]interface{}{"hello", "auth ok"})).
+				Should(MatchError("only 1 of 2 sequence logs were captured"))
+			Ω(logHook).Should(HaveLogs("hello"))
+		})
+		It("matches a pointer field against a value", func() {
+			count, name := 3, "widget"
+			logrus.WithFields(logrus.Fields{"count": &count, "name": name}).Info("made things")
+			Ω(logHook).Should(HaveLogs("made things", logrus.Fields{"count": 3, "name": &name}))
+		})
+		It("doesn't match a nil pointer field against a value", func() {
+			var count *int
+			logrus.WithFields(logrus.Fields{"count": count}).Info("made nothing")
+			Ω(logHook).ShouldNot(HaveLogs("made nothing", logrus.Fields{"count": 0}, time.Millisecond*100))
+			Ω(logHook).Should(HaveLogs("made nothing", logrus.Fields{"count": BeNil()}))
+		})
+		It("passes consistent field schemas", func() {
+			logrus.WithFields(logrus.Fields{"id": 1, "path": "/"}).Info("request handled")
+			logrus.WithFields(logrus.Fields{"id": 2, "path": "/x"}).Info("request handled")
+			logrus.Info("unrelated")
+			Ω(logHook).Should(HaveConsistentFieldSchema("request handled"))
+			Ω(logHook).Should(HaveLogs("request handled", "request handled", "unrelated"))
+		})
+		It("fails inconsistent field schemas", func() {
+			logrus.WithFields(logrus.Fields{"id": 1, "path": "/"}).Info("request handled")
+			logrus.WithFields(logrus.Fields{"id": 2}).Info("request handled")
+			h := HaveConsistentFieldSchema("request handled")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("[id path] in 1 entries"))
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("[id] in 1 entries"))
+			Ω(logHook).Should(HaveLogs("request handled", "request handled"))
+		})
+		It("numbers entries in capture order even when timestamps collide", func() {
+			now := time.Now()
+			logrus.WithTime(now).Info("first")
+			logrus.WithTime(now).Info("second")
+			first := <-logHook.AwaitLog("first")
+			second := <-logHook.AwaitLog("second")
+			Ω(first.Time).Should(Equal(second.Time))
+			firstSeq, ok := logHook.Sequence(first)
+			Ω(ok).Should(BeTrue())
+			secondSeq, ok := logHook.Sequence(second)
+			Ω(ok).Should(BeTrue())
+			Ω(secondSeq).Should(Equal(firstSeq + 1))
+			Ω(logHook.ExpectSequenceThenAny([]interface{}{"first", "second"})).Should(Succeed())
+		})
+		It("doesn't number entries it didn't capture", func() {
+			_, ok := logHook.Sequence(logrus.NewEntry(logrus.StandardLogger()))
+			Ω(ok).Should(BeFalse())
+		})
+		It("matches a valid UUID field", func() {
+			logrus.WithField("request_id", "5b0e4c2a-8f1d-4c6e-9a3b-2d7f1e0c9b8a").Info("request started")
+			Ω(logHook).Should(HaveLogs("request started", logrus.Fields{"request_id": ValidUUID}))
+		})
+		It("doesn't match an invalid UUID field", func() {
+			logrus.WithField("request_id", "5b0e4c2a-8f1d-4c6e-9a3b").Info("request started")
+			Ω(logHook).ShouldNot(HaveLogs("request started", logrus.Fields{"request_id": ValidUUID}, time.Millisecond*100))
+			Ω(logHook).Should(HaveLogs("request started", logrus.Fields{"request_id": Not(ValidUUID)}))
+		})
+		It("matches a field value distribution within tolerance", func() {
+			for i := 0; i < 100; i++ {
+				backend := "primary"
+				if i%5 == 0 {
+					backend = "replica"
+				}
+				logrus.WithField("backend", backend).Info("query")
+			}
+			Ω(logHook).Should(HaveFieldValueDistribution("backend",
+				map[interface{}]float64{"primary": 0.78, "replica": 0.22}, 0.05))
+			Ω(logHook).Should(HaveLogs(Repeater{"query", 100}))
+		})
+		It("fails a skewed field value distribution", func() {
+			for i := 0; i < 10; i++ {
+				logrus.WithField("backend", "primary").Info("query")
+			}
+			h := HaveFieldValueDistribution("backend",
+				map[interface{}]float64{"primary": 0.5, "replica": 0.5}, 0.05)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("Instead, got:\n    primary: 1.000"))
+			Ω(logHook).Should(HaveLogs(Repeater{"query", 10}))
+		})
+		It("tallies slice-valued fields in a distribution", func() {
+			logrus.WithField("backend", "primary").Info("query")
+			logrus.WithField("backend", []string{"a", "b"}).Info("query")
+			h := HaveFieldValueDistribution("backend", map[interface{}]float64{"primary": 1}, 0.05)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`[]string{"a", "b"}: 0.500`))
+			Ω(logHook).Should(HaveLogs(Repeater{"query", 2}))
+		})
+		It("redacts the values of a distribution", func() {
+			logHook.RedactFields("backend")
+			logrus.WithField("backend", "db-s3cret").Info("query")
+			h := HaveFieldValueDistribution("backend", map[interface{}]float64{"primary": 1}, 0.05)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(ContainSubstring("Instead, got:\n    ***: 1.000"))
+			Ω(message).ShouldNot(ContainSubstring("s3cret"))
+			Ω(logHook).Should(HaveLogs("query"))
+		})
+		It("returns records with their source", func() {
+			logrus.WithField("user", "bob").Warning("logged in")
+			_, file, line, _ := runtime.Caller(0)
+			records := logHook.EntriesWithSource()
+			Ω(records).Should(HaveLen(1))
+			Ω(records[0].Message).Should(Equal("logged in"))
+			Ω(records[0].Level).Should(Equal(logrus.WarnLevel))
+			Ω(records[0].Fields).Should(Equal(logrus.Fields{"user": "bob"}))
+			Ω(records[0].Source).Should(Equal(fmt.Sprintf("%s:%d", file, line-1)))
+			Ω(logHook).Should(HaveLogs("logged in"))
+		})
+		It("ignores fields when asked", func() {
+			logrus.WithFields(logrus.Fields{"status": "ok", "duration": 17}).Info("request done")
+			expected := logrus.Fields{"status": "ok", "duration": 0, "started": "never"}
+			Ω(logHook).ShouldNot(HaveLogs("request done", expected, time.Millisecond*100))
+			Ω(logHook).Should(HaveLogs("request done", expected, HaveLogsIgnoringFields("duration", "started")))
+		})
+		It("ignores keys required by HaveFieldKeys", func() {
+			logrus.WithFields(logrus.Fields{"status": "ok"}).Info("request done")
+			Ω(logHook).Should(HaveLogs("request done", HaveFieldKeys("status", "duration"), HaveLogsIgnoringFields("duration")))
+		})
+		It("passes fields that survive a formatter round trip", func() {
+			logrus.WithFields(logrus.Fields{"user": "bob", "count": 3, "err": io.EOF}).Info("logged in")
+			Ω(logHook).Should(HaveFormatterRoundTrip(&logrus.JSONFormatter{}))
+			Ω(logHook).Should(HaveLogs("logged in"))
+		})
+		It("fails fields that don't survive a formatter round trip", func() {
+			logrus.WithFields(logrus.Fields{"user": "bob", "msg": "clashes"}).Info("logged in")
+			h := HaveFormatterRoundTrip(&logrus.JSONFormatter{})
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`"msg" was "clashes", came back "logged in"`))
+			Ω(h.FailureMessage(logHook)).ShouldNot(ContainSubstring(`"user"`))
+			Ω(logHook).Should(HaveLogs("logged in"))
+		})
+		It("redacts field values that don't survive a formatter round trip", func() {
+			logHook.RedactFields("msg")
+			logrus.WithField("msg", "s3cret").Info("rotated")
+			h := HaveFormatterRoundTrip(&logrus.JSONFormatter{})
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`"msg" was "***", came back "***"`))
+			Ω(h.FailureMessage(logHook)).ShouldNot(ContainSubstring("s3cret"))
+			Ω(logHook).Should(HaveLogs("rotated"))
+		})
+		It("passes logs grouped by a field", func() {
+			logrus.WithField("tenant", "a").Info("one")
+			logrus.WithField("tenant", "a").Info("two")
+			logrus.Info("no tenant")
+			logrus.WithField("tenant", "b").Info("three")
+			Ω(logHook).Should(HaveLogsGroupedBy("tenant"))
+			Ω(logHook).Should(HaveLogs("one", "two", "no tenant", "three"))
+		})
+		It("fails interleaved groups", func() {
+			logrus.WithField("tenant", "a").Info("one")
+			logrus.WithField("tenant", "b").Info("two")
+			logrus.WithField("tenant", "a").Info("three")
+			h := HaveLogsGroupedBy("tenant")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`tenant=a came back after tenant=b:
+  "three" logged at`))
+			Ω(logHook).Should(HaveLogs("one", "two", "three"))
+		})
+		It("redacts the group values of interleaved groups", func() {
+			logHook.RedactFields("token")
+			logrus.WithField("token", "s3cret-a").Info("one")
+			logrus.WithField("token", "s3cret-b").Info("two")
+			logrus.WithField("token", "s3cret-a").Info("three")
+			h := HaveLogsGroupedBy("token")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(ContainSubstring("token=*** came back after token=***"))
+			Ω(message).ShouldNot(ContainSubstring("s3cret"))
+			Ω(logHook).Should(HaveLogs("one", "two", "three"))
+		})
+		It("measures the capture span", func() {
+			Ω(logHook.CaptureSpan()).Should(BeZero())
+			Ω(logHook).Should(HaveCaptureSpanUnder(time.Second))
+			start := time.Now()
+			logrus.WithTime(start).Info("begin")
+			logrus.WithTime(start.Add(time.Millisecond * 300)).Info("end")
+			Ω(logHook.CaptureSpan()).Should(Equal(time.Millisecond * 300))
+			Ω(logHook).Should(HaveCaptureSpanUnder(time.Second))
+			h := HaveCaptureSpanUnder(time.Millisecond * 100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(Equal("Expected logs to span less than 100ms. Instead, they spanned 300ms"))
+			Ω(logHook).Should(HaveLogs("begin", "end"))
+		})
+		It("matches a field against all of several regexps", func() {
+			logrus.WithField("order_id", "ORD-123456").Info("order placed")
+			Ω(logHook).Should(HaveLogs("order placed", logrus.Fields{"order_id": AllRegexp(`^ORD-`, `-\d{6}$`)}))
+		})
+		It("doesn't match a field when one regexp fails", func() {
+			logrus.WithField("order_id", "ORD-12345").Info("order placed")
+			Ω(logHook).ShouldNot(HaveLogs("order placed", logrus.Fields{"order_id": AllRegexp(`^ORD-`, `-\d{6}$`)}, time.Millisecond*100))
+			Ω(logHook).Should(HaveLogs("order placed", logrus.Fields{"order_id": AllRegexp(`^ORD-`, `-\d{5}$`)}))
+		})
+		It("diffs captured messages", func() {
+			logrus.Info("connecting")
+			logrus.Info("retrying")
+			logrus.Info("connected")
+			Ω(logHook.Diff([]string{"connecting", "retrying", "connected"})).Should(BeEmpty())
+			Ω(logHook).Should(MatchMessagesDiff([]string{"connecting", "retrying", "connected"}))
+			Ω(logHook.Diff([]string{"connecting", "connected", "ready"})).Should(Equal(
+				"--- expected\n+++ captured\n connecting\n+retrying\n connected\n-ready\n"))
+			h := MatchMessagesDiff([]string{"connecting", "connected"})
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("\n+retrying\n"))
+			Ω(logHook).Should(HaveLogs("connecting", "retrying", "connected"))
+		})
+		It("passes properly spaced repeats", func() {
+			start := time.Now()
+			logrus.WithTime(start).Info("cache full")
+			logrus.WithTime(start.Add(time.Millisecond * 50)).Info("something else")
+			logrus.WithTime(start.Add(time.Second)).Info("cache full")
+			Ω(logHook).Should(HaveNoRepeatWithin("cache full", time.Second))
+			Ω(logHook).Should(HaveLogs("cache full", "cache full", "something else"))
+		})
+		It("fails repeats that are too close", func() {
+			start := time.Now()
+			logrus.WithTime(start).Info("cache full")
+			logrus.WithTime(start.Add(time.Millisecond * 250)).Info("cache full")
+			h := HaveNoRepeatWithin("cache full", time.Second)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`Instead, got two 250ms apart:`))
+			Ω(logHook).Should(HaveLogs("cache full", "cache full"))
+		})
+		It("passes escalating levels", func() {
+			logrus.Info("retrying")
+			logrus.Info("unrelated")
+			logrus.Warning("still failing")
+			logrus.Warning("still failing")
+			logrus.Error("giving up")
+			Ω(logHook).Should(HaveEscalatingLevels("retrying", "still failing", "still failing", "giving up"))
+			Ω(logHook).Should(HaveLogs("retrying", "unrelated", "still failing", "still failing", "giving up"))
+		})
+		It("fails de-escalating levels", func() {
+			logrus.Warning("still failing")
+			logrus.Info("giving up")
+			h := HaveEscalatingLevels("still failing", "giving up")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("Instead, warning dropped to info:"))
+			h = HaveEscalatingLevels("giving up", "still failing")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`couldn't find "still failing" after 1 of them`))
+			Ω(logHook).Should(HaveLogs("still failing", "giving up"))
+		})
+		It("filters entries by a base field", func() {
+			api := logrus.WithField("svc", "api")
+			db := logrus.WithField("svc", "db")
+			api.Info("request in")
+			db.Info("query")
+			api.Info("request out")
+			entries := logHook.EntriesWithField("svc", "api")
+			Ω(entries).Should(HaveLen(2))
+			Ω(entries[0].Message).Should(Equal("request in"))
+			Ω(entries[1].Message).Should(Equal("request out"))
+			Ω(logHook.EntriesWithField("svc", HavePrefix("d"))).Should(HaveLen(1))
+			Ω(logHook.EntriesWithField("svc", "cache")).Should(BeEmpty())
+			Ω(logHook).Should(HaveLogs("request in", "query", "request out"))
+		})
+		It("passes properly formatted logs", func() {
+			logrus.Infof("user %s logged in", "bob")
+			logrus.Info("upload 50% done")
+			logrus.Info("disk at 100%")
+			Ω(logHook).Should(HaveNoUnformattedLogs())
+			Ω(logHook).Should(HaveLogs("user bob logged in", "upload 50% done", "disk at 100%"))
+		})
+		It("passes percent-encoded and literal percent messages", func() {
+			// Messages in variables keep go vet from flagging them.
+			messages := []interface{}{"GET /search?q=hello%20bob", "GET /files/a%2Fb%2F", "discount 10%off today"}
+			for _, message := range messages {
+				logrus.Info(message)
+			}
+			Ω(logHook).Should(HaveNoUnformattedLogs())
+			Ω(logHook).Should(HaveLogs(messages...))
+		})
+		It("fails verbs with a precision run into text", func() {
+			raw := "took %.2fs"
+			logrus.Info(raw)
+			Ω(logHook).ShouldNot(HaveNoUnformattedLogs())
+			Ω(logHook).Should(HaveLogs(raw))
+		})
+		It("fails unformatted logs", func() {
+			// Formats in variables keep go vet from catching these first.
+			unformatted, short := "user %s logged in", "took %.2fs for %d items"
+			logrus.Info(unformatted)
+			logrus.Infof(short, 1.5)
+			h := HaveNoUnformattedLogs()
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("Instead, got 2:\n  user %s logged in\n  logged at "))
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("%!d(MISSING)"))
+			Ω(logHook).Should(HaveLogs("user %s logged in", "took 1.50s for %!d(MISSING) items"))
+		})
+		It("writes nothing to the real output unless displaying", func() {
+			logger := logrus.New()
+			var out bytes.Buffer
+			logger.Out = &out
+			h := NewLogHook(logger)
+			h.Start()
+			defer h.Stop()
+			logger.Info("quiet")
+			logger.Error("also quiet")
+			Ω(h.OutputBytes()).Should(BeZero())
+			Ω(out.Len()).Should(BeZero())
+			var display bytes.Buffer
+			h.DisplayTo(&display, logrus.ErrorLevel)
+			logger.Error("shown")
+			Ω(h.OutputBytes()).Should(Equal(display.Len()))
+			Ω(display.String()).Should(ContainSubstring("shown"))
+			Ω(out.Len()).Should(BeZero())
+			Ω(h).Should(HaveLogs("quiet", "also quiet", "shown"))
+		})
+		It("returns every entry with Entries", func() {
+			logrus.Info("first")
+			Ω(logHook).Should(HaveLogs("first"))
+			logrus.WithField("n", 2).Warning("second")
+			entries := logHook.Entries()
+			Ω(entries).Should(HaveLen(2))
+			Ω(entries[0].Message).Should(Equal("first"))
+			Ω(entries[1].Level).Should(Equal(logrus.WarnLevel))
+			Ω(entries[1].Data["n"]).Should(Equal(2))
+			Ω(logHook).Should(HaveLogs("second"))
+		})
+		It("groups entries by level", func() {
+			logrus.Warning("warning one")
+			logrus.Error("error one")
+			logrus.Warning("warning two")
+			levels := logHook.ByLevel()
+			Ω(levels).Should(HaveLen(2))
+			Ω(levels[logrus.WarnLevel]).Should(HaveLen(2))
+			Ω(levels[logrus.WarnLevel][0].Message).Should(Equal("warning one"))
+			Ω(levels[logrus.WarnLevel][1].Message).Should(Equal("warning two"))
+			Ω(levels[logrus.ErrorLevel]).Should(HaveLen(1))
+			Ω(levels[logrus.ErrorLevel][0].Message).Should(Equal("error one"))
+			Ω(logHook).Should(HaveLogs("warning one", "warning two", "error one"))
+		})
+		It("groups entries by goroutine", func() {
+			var wg sync.WaitGroup
+			for _, worker := range []string{"a", "b"} {
+				wg.Add(1)
+				go func(worker string) {
+					defer wg.Done()
+					for i := 0; i < 20; i++ {
+						logrus.WithField("worker", worker).Infof("%s step %d", worker, i)
+					}
+				}(worker)
+			}
+			wg.Wait()
+			routines := logHook.EntriesByGoroutine()
+			Ω(routines).Should(HaveLen(2))
+			for _, entries := range routines {
+				Ω(entries).Should(HaveLen(20))
+				worker := entries[0].Data["worker"]
+				for i, entry := range entries {
+					Ω(entry.Message).Should(Equal(fmt.Sprintf("%s step %d", worker, i)))
+				}
+			}
+			Ω(logHook).Should(HaveLogs(Repeater{MatchRegexp(`^a step`), 20}, Repeater{MatchRegexp(`^b step`), 20}))
+		})
+		It("passes legal state transitions", func() {
+			logrus.WithField("state", "idle").Info("one")
+			logrus.WithField("state", "connecting").Info("two")
+			logrus.Info("stateless")
+			logrus.WithField("state", "connecting").Info("three")
+			logrus.WithField("state", "connected").Info("four")
+			Ω(logHook).Should(HaveLogStateMachine(connectionStates, "state"))
+			Ω(logHook).Should(HaveLogs("one", "two", "stateless", "three", "four"))
+		})
+		It("fails on the first illegal state transition", func() {
+			logrus.WithField("state", "idle").Info("one")
+			logrus.WithField("state", "connected").Info("two")
+			logrus.WithField("state", "connecting").Info("three")
+			h := HaveLogStateMachine(connectionStates, "state")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`it went from "idle" to "connected" (allowed: ["connecting"])`))
+			Ω(logHook).Should(HaveLogs("one", "two", "three"))
+		})
+		It("redacts the states of an illegal transition", func() {
+			logHook.RedactFields("state")
+			logrus.WithField("state", "idle").Info("one")
+			logrus.WithField("state", "connected").Info("two")
+			h := HaveLogStateMachine(connectionStates, "state")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(ContainSubstring(`it went from "***" to "***" (allowed: ["connecting"])`))
+			Ω(message).ShouldNot(ContainSubstring("idle"))
+			Ω(logHook).Should(HaveLogs("one", "two"))
+		})
+		It("asserts and clears across iterations", func() {
+			for i := 0; i < 3; i++ {
+				logrus.WithField("case", i).Info("running case")
+				logrus.Info("case done")
+				Ω(logHook.AssertAndClear("running case", logrus.Fields{"case": i}, "case done")).Should(Succeed())
+			}
+		})
+		It("keeps the capture when assert and clear fails", func() {
+			logrus.Info("running case")
+			err := logHook.AssertAndClear("case done", time.Millisecond*100)
+			Ω(err).Should(MatchError(ContainSubstring("case done")))
+			Ω(logHook).Should(HaveLogs("running case"))
+		})
+		It("matches logs in order", func() {
+			logrus.Info("connecting")
+			logrus.Info("unrelated")
+			logrus.WithField("attempt", 1).Info("connected")
+			Ω(logHook).Should(HaveLogsInOrder("connecting", logrus.Fields{}, "connected", logrus.Fields{"attempt": 1}))
+			Ω(logHook).Should(HaveLogs("unrelated"))
+		})
+		It("fails logs out of order", func() {
+			logrus.Info("connected")
+			logrus.Info("connecting")
+			h := HaveLogsInOrder("connecting", "connected", time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`got "connected" out of sequence`))
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("logcap_test.go"))
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("to equal\n    <string>: connecting"))
+			Ω(logHook).Should(HaveLogs("connected", "connecting"))
+		})
+		It("matches numeric fields within a tolerance", func() {
+			logrus.WithFields(logrus.Fields{
+				"float":  1.504,
+				"int":    2,
+				"uint":   uint8(3),
+				"string": " 4.49 ",
+			}).Info("metrics")
+			Ω(logHook).Should(HaveLogs("metrics", logrus.Fields{
+				"float":  FloatWithin(1.5, 0.01),
+				"int":    FloatWithin(2.25, 0.25),
+				"uint":   FloatWithin(3, 0),
+				"string": FloatWithin(4.5, 0.011),
+			}))
+		})
+		It("doesn't match numeric fields outside a tolerance", func() {
+			logrus.WithFields(logrus.Fields{"float": 1.52, "word": "many"}).Info("metrics")
+			Ω(logHook).ShouldNot(HaveLogs("metrics", logrus.Fields{"float": FloatWithin(1.5, 0.01)}, time.Millisecond*100))
+			Ω(logHook).ShouldNot(HaveLogs("metrics", logrus.Fields{"word": FloatWithin(0, 1e9)}, time.Millisecond*100))
+			Ω(FloatWithin(1.5, 0.01).FailureMessage(1.52)).Should(ContainSubstring("to be a number within 0.01 of 1.5"))
+			Ω(logHook).Should(HaveLogs("metrics", logrus.Fields{"float": FloatWithin(1.5, 0.03)}))
+		})
+		It("matches a wrapped error chain", func() {
+			err := fmt.Errorf("save: %w", fmt.Errorf("write: %w", io.ErrShortWrite))
+			logrus.WithError(err).Error("failed")
+			Ω(logHook).Should(HaveLoggedErrorChain("save", "write", "short write"))
+			Ω(logHook).Should(HaveLoggedErrorChain("write", "short write"))
+			Ω(logHook).ShouldNot(HaveLoggedErrorChain("short write", "save"))
+			Ω(logHook).Should(HaveLogs("failed"))
+		})
+		It("doesn't match a flattened error chain", func() {
+			err := fmt.Errorf("save: %v", fmt.Errorf("write: %v", io.ErrShortWrite))
+			logrus.WithError(err).Error("failed")
+			h := HaveLoggedErrorChain("save", "write")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring(`got 1 error chains:
+  "save: write: short write"
+    logged at`))
+			Ω(logHook).Should(HaveLogs("failed"))
+		})
+		It("matches on level", func() {
+			logrus.Error("disk full")
+			logrus.Warning("disk nearly full")
+			logrus.Info("disk fine")
+			Ω(logHook).Should(HaveLogs("disk full", logrus.ErrorLevel, "disk nearly full", logrus.WarnLevel, "disk fine"))
+		})
+		It("shows expected and actual level on failure", func() {
+			logrus.Warning("disk full")
+			h := HaveLogs("disk full", logrus.ErrorLevel, time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("is at level warning, expected error"))
+			Ω(logHook).Should(HaveLogs("disk full", logrus.WarnLevel))
+		})
+		It("exposes matched entries", func() {
+			logrus.WithField("request_id", "abc123").Info("request started")
+			logrus.Info("request done")
+			h := HaveLogs("request done", "request started")
+			Ω(logHook).Should(h)
+			entries := h.MatchedEntries()
+			Ω(entries).Should(HaveLen(2))
+			Ω(entries[0].Message).Should(Equal("request done"))
+			Ω(entries[1].Data["request_id"]).Should(Equal("abc123"))
+		})
+		It("leaves unmatched entries nil", func() {
+			logrus.Info("request started")
+			h := HaveLogs("request started", "request done", time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			entries := h.MatchedEntries()
+			Ω(entries).Should(HaveLen(2))
+			Ω(entries[0].Message).Should(Equal("request started"))
+			Ω(entries[1]).Should(BeNil())
+		})
+		It("lists what didn't match", func() {
+			logrus.Info("request started")
+			logrus.Info("cache miss")
+			h := HaveLogs("request started", MatchRegexp("request (done|failed)"), time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			expectations, entries := h.Unmatched()
+			Ω(expectations).Should(HaveLen(1))
+			Ω(expectations[0]).Should(ContainSubstring("request (done|failed)"))
+			Ω(entries).Should(HaveLen(1))
+			Ω(entries[0].Message).Should(Equal("cache miss"))
+			Ω(logHook).Should(HaveLogs("cache miss"))
+		})
+		It("resets between phases", func() {
+			logrus.Info("phase one")
+			Ω(logHook).Should(HaveLogs("phase one"))
+			logrus.Info("phase one leftover")
+			logHook.Reset()
+			Ω(logHook).Should(HaveNoLogs())
+			logrus.Info("phase two")
+			Ω(logHook).Should(HaveLogs("phase two"))
+		})
+		It("resets safely while logging", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					logrus.Info("background")
+				}
+			}()
+			for i := 0; i < 10; i++ {
+				logHook.Reset()
+			}
+			<-done
+			logHook.Reset()
+		})
+		It("matches on a level given as a string", func() {
+			logrus.Warning("disk nearly full")
+			logrus.Error("disk full")
+			Ω(logHook).ShouldNot(HaveLogs("disk full", LevelString("warning"), time.Millisecond*100))
+			Ω(logHook).Should(HaveLogs("disk nearly full", LevelString("warning"), "disk full", LevelString("ERROR")))
+		})
+		It("errors on an unknown level string", func() {
+			_, err := HaveLogs("disk full", LevelString("loud")).Match(logHook)
+			Ω(err).Should(MatchError(ContainSubstring(`not a valid logrus Level: "loud"`)))
+		})
+		It("counts distinct call sites", func() {
+			logrus.Info("one")
+			logrus.Info("two")
+			for i := 0; i < 3; i++ {
+				logrus.Info("three")
+			}
+			Ω(logHook).Should(HaveDistinctCallSites(3))
+			Ω(logHook).ShouldNot(HaveDistinctCallSites(4))
+			Ω(logHook).Should(HaveLogs("one", "two", Repeater{"three", 3}))
+		})
+		It("fails logs from a single call site", func() {
+			for i := 0; i < 3; i++ {
+				logrus.Info("same")
+			}
+			h := HaveDistinctCallSites(2)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(MatchRegexp(`Instead, got 1:\n  .*logcap_test.go:\d+ \(3 logs\)`))
+			Ω(logHook).Should(HaveLogs(Repeater{"same", 3}))
+		})
+		It("allows less severe logs with HaveNoLogsAbove", func() {
+			logrus.Info("just info")
+			logrus.Debug("just debug")
+			Ω(logHook).Should(HaveNoLogsAbove(logrus.WarnLevel))
+			Ω(logHook).Should(HaveLogs("just info", "just debug"))
+		})
+		It("lists logs at or above the level with HaveNoLogsAbove", func() {
+			logrus.Info("just info")
+			logrus.Warning("a warning")
+			logrus.Error("an error")
+			h := HaveNoLogsAbove(logrus.WarnLevel)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("Instead, got 2:\n  warning: a warning\n"))
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("\n  error: an error\n"))
+			Ω(h.FailureMessage(logHook)).ShouldNot(ContainSubstring("just info"))
+			Ω(logHook).Should(HaveLogs("just info", "a warning", "an error"))
+		})
+		It("polls safely while another goroutine logs", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 50; i++ {
+					logrus.WithField("i", i).Info("background")
+					time.Sleep(time.Millisecond)
+				}
+				logrus.Info("background done")
+			}()
+			Ω(logHook).Should(HaveLogs("background done"))
+			<-done
+			Ω(logHook).ShouldNot(HaveNoLogs())
+			Ω(logHook).Should(HaveLogs(Repeater{"background", 50}))
+		})
+		It("matches logs between Begin and End with HaveLogsBetween", func() {
+			logrus.Info("before")
+			logHook.Begin("tx")
+			logrus.Info("inside")
+			logHook.End("tx")
+			logrus.Info("after")
+			Ω(logHook).Should(HaveLogsBetween("tx", "inside"))
+			Ω(logHook).ShouldNot(HaveLogsBetween("tx", "before", time.Millisecond))
+			Ω(logHook).ShouldNot(HaveLogsBetween("tx", "after", time.Millisecond))
+			Ω(logHook).Should(HaveLogs("before", "after"))
+		})
+		It("treats a span without End as still open", func() {
+			logHook.Begin("tx")
+			logrus.Info("first")
+			Ω(logHook).Should(HaveLogsBetween("tx", "first"))
+			logrus.Info("second")
+			Ω(logHook).Should(HaveLogsBetween("tx", "second"))
+		})
+		It("errors on a span that was never begun", func() {
+			_, err := HaveLogsBetween("nope", "anything").Match(logHook)
+			Ω(err).Should(MatchError(`no span named "nope", call Begin("nope") first`))
+		})
+		It("tracks the buffer's high-water mark", func() {
+			for i := 0; i < 100; i++ {
+				logrus.Info("burst")
+			}
+			Ω(logHook.HighWaterMark()).Should(Equal(100))
+			Ω(logHook).Should(HaveLogs(Repeater{"burst", 100}))
+			logrus.Info("trickle")
+			Ω(logHook.HighWaterMark()).Should(Equal(100))
+			Ω(logHook).Should(HaveBufferHighWaterUnder(101))
+			h := HaveBufferHighWaterUnder(100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(Equal("Expected the buffer to hold fewer than 100 entries at once. Instead, it held 100"))
+			Ω(logHook).Should(HaveLogs("trickle"))
+		})
+		It("counts captured entries, matched or not", func() {
+			logrus.Info("one")
+			logrus.Warn("two")
+			logrus.Warn("three")
+			Ω(logHook.Count()).Should(Equal(3))
+			Ω(logHook.CountAtLevel(logrus.WarnLevel)).Should(Equal(2))
+			Ω(logHook.CountAtLevel(logrus.ErrorLevel)).Should(BeZero())
+			Ω(logHook).Should(HaveLogs("one", "two", "three"))
+			Ω(logHook.Count()).Should(Equal(3))
+			logHook.Reset()
+			Ω(logHook.Count()).Should(BeZero())
+		})
+		It("strips a constant prefix with HaveLogsWithPrefix", func() {
+			logrus.Info("[api] listening")
+			logrus.Info("[api] served 12 requests")
+			logrus.Info("listening")
+			Ω(logHook).Should(HaveLogsWithPrefix("[api] ", "listening", MatchRegexp(`^served \d+`)))
+			Ω(logHook).ShouldNot(HaveLogsWithPrefix("[api] ", "listening", time.Millisecond))
+			Ω(logHook).Should(HaveLogs("listening"))
+		})
+		It("fingerprints the capture", func() {
+			run := func(user string) string {
+				logHook.Reset()
+				logrus.WithFields(logrus.Fields{"user": user, "n": 2}).Info("login")
+				logrus.Warn("slow")
+				fingerprint := logHook.Fingerprint()
+				Ω(logHook).Should(HaveLogs("login", "slow"))
+				return fingerprint
+			}
+			first := run("ann")
+			Ω(first).Should(HaveLen(64))
+			Ω(run("ann")).Should(Equal(first))
+			Ω(run("bob")).ShouldNot(Equal(first))
+			logHook.Reset()
+			logrus.WithFields(logrus.Fields{"n": 2, "user": "ann"}).Info("login")
+			logrus.Warn("slow")
+			Ω(logHook).Should(MatchFingerprint(first))
+			logrus.Warn("extra")
+			Ω(logHook).ShouldNot(MatchFingerprint(first))
+			Ω(logHook).Should(HaveLogs("login", "slow", "extra"))
+		})
+		It("requires Absent fields to be missing", func() {
+			logrus.WithFields(logrus.Fields{"user": "ann"}).Info("login")
+			logrus.WithFields(logrus.Fields{"user": "bob", "password": "hunter2"}).Info("login")
+			h := HaveLogs("login", logrus.Fields{"user": "bob", "password": Absent}, time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("has keys [password] that should be absent"))
+			Ω(logHook).Should(HaveLogs("login", logrus.Fields{"user": "ann", "password": Absent}))
+			Ω(logHook).Should(HaveLogs("login", logrus.Fields{"user": "bob"}))
+		})
+		It("gives up after the timeout even while other logs keep coming", func() {
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for {
+					select {
+					case <-stop:
+						return
+					case <-time.After(time.Millisecond * 5):
+						logrus.Info("chatter")
+					}
+				}
+			}()
+			start := time.Now()
+			Ω(logHook).ShouldNot(HaveLogs("never logged", time.Millisecond*100))
+			Ω(time.Since(start)).Should(BeNumerically("<", time.Millisecond*500))
+			close(stop)
+			<-done
+			logHook.Reset()
+		})
+		It("requires a field on every matching log with HaveLogsAlwaysWithField", func() {
+			logrus.WithField("transaction_id", "t1").Info("payment processed")
+			logrus.WithField("amount", 5).Info("payment processed")
+			logrus.Info("refund issued")
+			h := HaveLogsAlwaysWithField("payment processed", "transaction_id")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(HavePrefix(`Expected every log matching "payment processed" to have a "transaction_id" field. Instead, 1 of 2 didn't:` + "\n  payment processed (map[amount:5])\n  logged at "))
+			Ω(logHook).ShouldNot(HaveLogsAlwaysWithField(HavePrefix("refund"), "transaction_id"))
+			Ω(logHook).Should(HaveLogsAlwaysWithField("no such log", "transaction_id"))
+			Ω(logHook).Should(HaveLogs("payment processed", "payment processed", "refund issued"))
+		})
+		It("matches logs whose context deadline was exceeded", func() {
+			logrus.Info("no context")
+			Ω(logHook).ShouldNot(HaveLogWithDeadlineExceeded())
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			logrus.WithContext(ctx).Info("canceled")
+			Ω(logHook).ShouldNot(HaveLogWithDeadlineExceeded())
+			ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			<-ctx.Done()
+			logrus.WithContext(ctx).Warn("timed out")
+			Ω(logHook).Should(HaveLogWithDeadlineExceeded())
+			Ω(logHook).Should(HaveLogs("no context", "canceled", "timed out"))
+		})
+		It("matches a range of repeats with RepeaterRange", func() {
+			for i := 0; i < 4; i++ {
+				logrus.Info("retrying")
+			}
+			logrus.Info("gave up")
+			Ω(logHook).Should(HaveLogs(RepeaterRange{"retrying", 3, 10}, "gave up"))
+		})
+		It("allows no upper limit with a zero Max", func() {
+			for i := 0; i < 20; i++ {
+				logrus.Info("retrying")
+			}
+			m := HaveLogs(RepeaterRange{"retrying", 3, 0})
+			Ω(logHook).Should(m)
+			Ω(m.MatchedEntries()[0].Message).Should(Equal("retrying"))
+		})
+		It("leaves an unmatched range that allows none out of MatchedEntries", func() {
+			logrus.Info("other")
+			h := HaveLogs(RepeaterRange{"retrying", 0, 3}, "other")
+			Ω(h.Match(logHook)).Should(BeTrue())
+			entries := h.MatchedEntries()
+			Ω(entries[0]).Should(BeNil())
+			Ω(entries[1].Message).Should(Equal("other"))
+		})
+		It("describes an unmatched range that allows none when negated", func() {
+			logrus.Info("other")
+			h := HaveLogs(RepeaterRange{"retrying", 0, 3}, "other")
+			Ω(h.Match(logHook)).Should(BeTrue())
+			Ω(h.NegatedFailureMessage(logHook)).Should(ContainSubstring(
+				"Expected logs matching \"retrying\" not to match\n"))
+			Ω(h.NegatedFailureMessage(logHook)).Should(ContainSubstring("matched 0 times, expected 0 to 3\n"))
+		})
+		It("reports too few repeats", func() {
+			logrus.Info("retrying")
+			logrus.Info("retrying")
+			h := HaveLogs(RepeaterRange{"retrying", 3, 10}, time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("matched 2 times, expected 3 to 10\n"))
+		})
+		It("reports too many repeats", func() {
+			for i := 0; i < 4; i++ {
+				logrus.Info("retrying")
+			}
+			h := HaveLogs(RepeaterRange{"retrying", 1, 3})
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(HavePrefix(`Expected 1 to 3 logs matching "retrying". Instead, got more than 3; the next was logged at `))
+			Ω(logHook).Should(HaveLogs("retrying"))
+		})
+		It("matches recent logs with WithinLast", func() {
+			logrus.Info("fresh")
+			logrus.WithTime(time.Now().Add(-time.Minute)).Info("stale")
+			Ω(logHook).Should(HaveLogs("fresh", WithinLast(time.Second*5)))
+			h := HaveLogs("stale", WithinLast(time.Second*5), time.Millisecond*100)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(MatchRegexp(`was logged at \S+, more than 5s ago`))
+			Ω(logHook).Should(HaveLogs("stale", WithinLast(time.Minute*2)))
+		})
+		It("takes timeouts from a suite budget", func() {
+			SetTimeoutBudget(time.Second * 10)
+			defer SetTimeoutBudget(0)
+			Ω(Budget(0.5).timeout()).Should(BeNumerically("~", time.Second*5, time.Millisecond*100))
+			SetTimeoutBudget(time.Second)
+			start := time.Now()
+			Ω(logHook).ShouldNot(HaveLogs("never logged", Budget(0.1)))
+			Ω(time.Since(start)).Should(BeNumerically("~", time.Millisecond*100, time.Millisecond*80))
+			SetTimeoutBudget(0)
+			Ω(Budget(0.1).timeout()).Should(Equal(defaultTimeout()))
+		})
+		It("matches logs that already arrived once the budget is spent", func() {
+			SetTimeoutBudget(time.Millisecond)
+			defer SetTimeoutBudget(0)
+			time.Sleep(time.Millisecond * 5)
+			for i := 0; i < 50; i++ {
+				for j := 0; j < 5; j++ {
+					logrus.Info("arrived")
+				}
+				Ω(logHook).Should(HaveLogs(Repeater{"arrived", 5}, Budget(1)))
+			}
+		})
+		It("checks fields against a JSON schema", func() {
+			schema := `{
+				"type": "object",
+				"required": ["request_id"],
+				"properties": {
+					"request_id": {"type": "string", "pattern": "^req-"},
+					"status": {"type": "integer", "minimum": 100, "maximum": 599},
+					"tags": {"type": "array", "items": {"type": "string"}}
+				},
+				"additionalProperties": false
+			}`
+			logrus.WithFields(logrus.Fields{"request_id": "req-1", "status": 200, "tags": []string{"a"}}).Info("ok")
+			Ω(logHook).Should(HaveLogsMatchingSchema(schema))
+			logrus.WithFields(logrus.Fields{"request_id": "1", "status": 700, "user": "ann"}).Info("bad")
+			logrus.WithFields(logrus.Fields{"status": "200"}).Info("worse")
+			h := HaveLogsMatchingSchema(schema)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(HavePrefix("Expected every log's fields to match the schema. Instead, got 2 failures:\n"))
+			Ω(message).Should(ContainSubstring(`fields.request_id is "1", doesn't match "^req-"`))
+			Ω(message).Should(ContainSubstring("fields.status is 700, more than 599"))
+			Ω(message).Should(ContainSubstring("fields.user isn't allowed"))
+			Ω(message).Should(ContainSubstring(`"worse" logged at`))
+			Ω(message).Should(ContainSubstring(`fields is missing "request_id"`))
+			Ω(message).Should(ContainSubstring("fields.status should be integer, is string"))
+			Ω(logHook).Should(HaveLogs("ok", "bad", "worse"))
+			_, err := HaveLogsMatchingSchema("{").Match(logHook)
+			Ω(err).Should(MatchError(HavePrefix("bad schema: ")))
+		})
+		It("redacts field values in schema violations", func() {
+			logHook.RedactFields("password")
+			logrus.WithField("password", "hunter2").Info("login")
+			h := HaveLogsMatchingSchema(`{"properties": {"password": {"type": "string", "pattern": "^\\$2y\\$"}}}`)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(ContainSubstring(`fields.password is "***", doesn't match`))
+			Ω(message).ShouldNot(ContainSubstring("hunter2"))
+			Ω(logHook).Should(HaveLogs("login"))
+		})
+		It("checks that logs alternate with HaveInterleavedLogs", func() {
+			logrus.Info("produced 1")
+			logrus.Info("consumed 1")
+			logrus.Info("idle")
+			logrus.Info("produced 2")
+			logrus.Info("consumed 2")
+			Ω(logHook).Should(HaveInterleavedLogs(HavePrefix("produced"), HavePrefix("consumed")))
+			logrus.Info("consumed 3")
+			h := HaveInterleavedLogs(HavePrefix("produced"), HavePrefix("consumed"))
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(MatchRegexp(`after 4 in turn, expected .*produced.* but got "consumed 3"\n    logged at \S+\nright after "consumed 2"`))
+			Ω(logHook).ShouldNot(HaveInterleavedLogs("a", "b"))
+			Ω(logHook).Should(HaveLogs("produced 1", "consumed 1", "idle", "produced 2", "consumed 2", "consumed 3"))
+		})
+		It("adds derived tags with TagEntries", func() {
+			logHook.TagEntries(func(e *logrus.Entry) map[string]interface{} {
+				ms, _ := e.Data["ms"].(int)
+				return map[string]interface{}{"slow": ms > 100, "ms": "overwritten"}
+			})
+			logrus.WithField("ms", 250).Info("query")
+			logrus.WithField("ms", 5).Info("query")
+			Ω(logHook).Should(HaveLogs(
+				"query", logrus.Fields{"slow": true, "ms": 250},
+				"query", logrus.Fields{"slow": false, "ms": 5},
+			))
+		})
+		It("requires a stack on error logs with HaveErrorLogsWithStack", func() {
+			logrus.Info("no stack needed")
+			logrus.WithField("stack", "main.go:12\nmain.go:3").Error("failed")
+			Ω(logHook).Should(HaveErrorLogsWithStack("stack"))
+			logrus.WithField("stack", "").Error("failed quietly")
+			logrus.Error("failed without a trace")
+			h := HaveErrorLogsWithStack("stack")
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(HavePrefix(`Expected every error log to have a "stack" field. Instead, 2 of 3 didn't:`))
+			Ω(message).Should(ContainSubstring("\n  failed quietly\n  logged at "))
+			Ω(message).Should(ContainSubstring("\n  failed without a trace\n  logged at "))
+			Ω(logHook).Should(HaveLogs("no stack needed", "failed", "failed quietly", "failed without a trace"))
+		})
+		It("matches into nested map fields", func() {
+			logrus.WithField("req", map[string]interface{}{
+				"method":  "GET",
+				"path":    "/users",
+				"headers": map[string]string{"accept": "json"},
+			}).Info("handled")
+			Ω(logHook).ShouldNot(HaveLogs("handled", logrus.Fields{"req": logrus.Fields{"method": "POST"}}, time.Millisecond))
+			Ω(logHook).ShouldNot(HaveLogs("handled", logrus.Fields{"req": logrus.Fields{"body": Absent, "path": Absent}}, time.Millisecond))
+			Ω(logHook).ShouldNot(HaveLogs("handled", logrus.Fields{"req": logrus.Fields{"path": logrus.Fields{"x": 1}}}, time.Millisecond))
+			Ω(logHook).Should(HaveLogs("handled", logrus.Fields{"req": logrus.Fields{
+				"method":  "GET",
+				"path":    HavePrefix("/users"),
+				"headers": map[string]interface{}{"accept": "json"},
+				"body":    Absent,
+			}}))
+		})
+		It("fails at once with HaveLogsNow", func() {
+			logrus.Info("already here")
+			Ω(logHook).Should(HaveLogsNow("already here"))
+			start := time.Now()
+			Ω(logHook).ShouldNot(HaveLogsNow("not yet", time.Second))
+			Ω(time.Since(start)).Should(BeNumerically("<", time.Millisecond*50))
+		})
+		It("waits for N matching logs with WaitForN", func() {
+			ticker := time.NewTicker(time.Millisecond * 5)
+			defer ticker.Stop()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 3; i++ {
+					<-ticker.C
+					logrus.WithField("n", i).Info("tick")
+					logrus.Info("tock")
+				}
+			}()
+			ticks, err := logHook.WaitForN("tick", 3, time.Second)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ticks).Should(HaveLen(3))
+			Ω(ticks[2].Data["n"]).Should(Equal(2))
+			<-done
+			ticks, err = logHook.WaitForN("tick", 4, time.Millisecond*50)
+			Ω(err).Should(MatchError(`found 3 of 4 logs matching "tick"`))
+			Ω(ticks).Should(HaveLen(3))
+			Ω(logHook).Should(HaveLogs(Repeater{"tick", 3}, Repeater{"tock", 3}))
+		})
+		It("counts matching logs with CountLogs", func() {
+			for i := 0; i < 12; i++ {
+				logrus.Infof("retry %d", i)
+			}
+			logrus.Info("gave up")
+			Ω(logHook).Should(CountLogs(ContainSubstring("retry"), BeNumerically(">=", 10)))
+			Ω(logHook).Should(CountLogs("gave up", Equal(1)))
+			h := CountLogs(ContainSubstring("retry"), BeNumerically(">", 20), time.Millisecond*50)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("<int>: 12"))
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				time.Sleep(time.Millisecond * 20)
+				logrus.Info("retry late")
+			}()
+			Ω(logHook).Should(CountLogs(ContainSubstring("retry"), Equal(13)))
+			<-done
+			Ω(logHook).Should(HaveLogs(Repeater{HavePrefix("retry"), 13}, "gave up"))
+		})
+		It("allows info logs with HaveNoLogsAtOrAbove", func() {
+			logrus.Info("fine")
+			logrus.Debug("also fine")
+			Ω(logHook).Should(HaveNoLogsAtOrAbove(logrus.WarnLevel))
+			Ω(logHook).Should(HaveLogs("fine", "also fine"))
+		})
+		It("fails on warnings with HaveNoLogsAtOrAbove, matched or not", func() {
+			logrus.Info("fine")
+			logrus.Warn("careful")
+			Ω(logHook).Should(HaveLogs("fine", "careful"))
+			h := HaveNoLogsAtOrAbove(logrus.WarnLevel)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(HavePrefix("Expected no logs. Instead, got 1:\n  warning: careful\n"))
+			Ω(logHook).Should(HaveNoLogsAtOrAbove(logrus.WarnLevel, false))
+		})
+		It("stops waiting when a context argument is canceled", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+			defer cancel()
+			start := time.Now()
+			Ω(logHook).ShouldNot(HaveLogs("never logged", ctx))
+			Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+			logrus.Info("logged")
+			Ω(logHook).Should(HaveLogs("logged"))
+		})
+		It("matches messages rendered with precision verbs", func() {
+			logrus.Infof("took %.2fs", 1.23456)
+			logrus.Infof("[%8.3f]", 3.14159)
+			logrus.Infof("load %5.1f%%", 42.0)
+			Ω(logHook).Should(HaveLogfMatch("took %.2fs", 1.23456))
+			Ω(logHook).Should(HaveLogfMatch("[%8.3f]", 3.14159))
+			Ω(logHook).Should(HaveLogs(MatchRegexp(`^load +42\.0%$`)))
+		})
+		It("blocks until a log appears with WaitFor", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				logrus.Info("starting")
+				time.Sleep(time.Millisecond * 20)
+				logrus.Info("listening on :8080")
+			}()
+			Ω(logHook.WaitFor(ContainSubstring("listening"), time.Second)).Should(Succeed())
+			<-done
+			Ω(logHook.WaitFor(Equal("stopped"), time.Millisecond*50)).Should(MatchError(ContainSubstring("found 0 of 1 logs matching")))
+			Ω(logHook).Should(HaveLogs("starting", "listening on :8080"))
+		})
+		It("checks that nothing precedes a gate with HaveNoLogsBefore", func() {
+			logrus.Info("service ready")
+			logrus.Info("handled request")
+			Ω(logHook).Should(HaveNoLogsBefore("service ready"))
+			Ω(logHook).ShouldNot(HaveNoLogsBefore("service stopped"))
+			Ω(logHook).Should(HaveLogs("service ready", "handled request"))
+			logHook.Reset()
+			logrus.Info("handled request")
+			logrus.Info("service ready")
+			h := HaveNoLogsBefore(HaveSuffix("ready"))
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(MatchRegexp(`Expected no logs before .*ready.*\. Instead, got 1:\n  info: handled request\n  logged at .*logcap_test.go:\d+`))
+			Ω(logHook).Should(HaveLogs("handled request", "service ready"))
+		})
+		It("matches error fields with MatchError", func() {
+			logrus.WithError(fmt.Errorf("read config: %w", io.EOF)).Error("failed")
+			logrus.WithField("error", "EOF").Error("failed again")
+			Ω(logHook).Should(HaveLogs(
+				"failed", logrus.Fields{"error": MatchError(io.EOF)},
+				"failed again", logrus.Fields{"error": MatchError(io.EOF)},
+			))
+			logrus.WithField("error", "unexpected EOF").Error("failed once more")
+			Ω(logHook).ShouldNot(HaveLogs("failed once more", logrus.Fields{"error": MatchError(io.EOF)}, time.Millisecond*50))
+			Ω(logHook).Should(HaveLogs("failed once more", logrus.Fields{"error": MatchError(ContainSubstring("EOF"))}))
+		})
+		It("matches keys of a JSON message with JSONMessage", func() {
+			logrus.WithField("user", "ann").Info(`{"event":"login","attempt":2,"geo":{"country":"NZ"}}`)
+			logrus.Info("not json")
+			Ω(logHook).Should(HaveLogs(
+				JSONMessage(logrus.Fields{
+					"event":   "login",
+					"attempt": 2,
+					"geo":     logrus.Fields{"country": HavePrefix("N")},
+					"admin":   Absent,
+				}),
+				logrus.Fields{"user": "ann"},
+			))
+			h := HaveLogs(JSONMessage(logrus.Fields{"event": "logout"}), time.Millisecond*50)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("to be a JSON object"))
+			Ω(logHook).Should(HaveLogs("not json"))
+		})
+		It("matches substrings ignoring case with ContainLog", func() {
+			logrus.Error("Connection RESET by peer (retry 1.5s)")
+			Ω(logHook).ShouldNot(HaveLogs(ContainLog("connection refused"), time.Millisecond*50))
+			Ω(logHook).Should(HaveLogs(ContainLog("(RETRY 1.5S)")))
+		})
+		It("catches Fatal logs with CatchExit", func() {
+			var after bool
+			code, exited := logHook.CatchExit(func() {
+				logrus.Fatal("shutting down")
+				after = true
+			})
+			Ω(exited).Should(BeTrue())
+			Ω(code).Should(Equal(1))
+			Ω(after).Should(BeFalse())
+			code, exited = logHook.CatchExit(func() {
+				logrus.Info("staying up")
+			})
+			Ω(exited).Should(BeFalse())
+			Ω(code).Should(BeZero())
+			Ω(func() { logrus.Panic("corrupt state") }).Should(Panic())
+			Ω(logHook).Should(HaveLogs(
+				"shutting down", logrus.FatalLevel,
+				"staying up", logrus.InfoLevel,
+				"corrupt state", logrus.PanicLevel,
+			))
+		})
+		It("takes a suite-wide default timeout", func() {
+			Ω(HaveLogs("anything").Timeout()).Should(Equal(time.Second * 2))
+			SetDefaultTimeout(time.Millisecond * 50)
+			defer SetDefaultTimeout(time.Second * 2)
+			h := HaveLogs("never logged")
+			Ω(h.Timeout()).Should(Equal(time.Millisecond * 50))
+			start := time.Now()
+			Ω(logHook).ShouldNot(h)
+			Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+			Ω(HaveLogs("anything", time.Second).Timeout()).Should(Equal(time.Second))
+		})
+		It("leaves the capture alone when checking for no logs", func() {
+			logrus.Info("first")
+			logrus.Warning("second")
+			Ω(logHook).ShouldNot(HaveNoLogs())
+			Ω(logHook).ShouldNot(HaveNoLogs(logrus.WarnLevel))
+			Ω(logHook).ShouldNot(HaveNoLogs())
+			Ω(logHook.Count()).Should(Equal(2))
+			Ω(logHook).Should(HaveLogs("first", "second"))
+			Ω(logHook).Should(HaveNoLogs())
+			Ω(logHook).Should(HaveNoLogs())
+			Ω(logHook.Count()).Should(Equal(2))
+		})
+		It("checks that a message never appears with NeverLogs", func() {
+			logrus.Info("handled request")
+			Ω(logHook).Should(NeverLogs(ContainSubstring("secret"), time.Millisecond*50))
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				time.Sleep(time.Millisecond * 20)
+				logrus.Warning("secret leaked")
+			}()
+			h := NeverLogs(ContainSubstring("secret"))
+			start := time.Now()
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+			<-done
+			Ω(h.FailureMessage(logHook)).Should(MatchRegexp(`Instead, got "secret leaked"\n    logged at .*logcap_test.go:\d+`))
+			Ω(logHook).Should(HaveLogs("handled request", "secret leaked"))
+		})
+		It("checks for back-to-back logs with HaveLogSequence", func() {
+			logrus.Info("begin")
+			logrus.Info("update")
+			logrus.Info("commit")
+			Ω(logHook).Should(HaveLogSequence("begin", "update", "commit"))
+			Ω(logHook).Should(HaveLogs("begin", "update", "commit"))
+			logHook.Reset()
+			logrus.Info("begin")
+			logrus.Info("update")
+			logrus.Info("cache miss")
+			logrus.Info("commit")
+			h := HaveLogSequence("begin", "update", MatchRegexp("^commit"))
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(MatchRegexp(`Instead, after "update"\n    logged at .*logcap_test.go:\d+\ngot 1 before .*commit.*:\n  cache miss\n  logged at .*logcap_test.go:\d+$`))
+			Ω(logHook).ShouldNot(HaveLogSequence("rollback"))
+			Ω(logHook).Should(HaveLogs("begin", "update", "cache miss", "commit"))
+		})
+		It("rejects extra fields with ExactFields", func() {
+			logrus.WithFields(logrus.Fields{"user": "ann", "method": "sso"}).Info("login")
+			Ω(logHook).Should(HaveLogs("login", ExactFields{"user": "ann", "method": HavePrefix("s")}))
+			logrus.WithFields(logrus.Fields{"user": "bob", "token": "s3cret"}).Info("login")
+			h := HaveLogs("login", ExactFields{"user": "bob", "method": "sso"}, time.Millisecond*50)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(ContainSubstring("with exactly logrus.Fields{"))
+			Ω(message).Should(ContainSubstring("has extra keys [token]\n"))
+			Ω(message).Should(ContainSubstring("lacks keys [method]\n"))
+			Ω(logHook).Should(HaveLogs("login", ExactFields{"user": "bob"}, HaveLogsIgnoringFields("token")))
+		})
+		It("summarizes what matched", func() {
+			logrus.Info("started")
+			logrus.Info("served 3")
+			logrus.Info("served 4")
+			h := HaveLogs("started", RepeaterRange{M: HavePrefix("served"), Min: 1})
+			Ω(logHook).Should(h)
+			Ω(h.Summary()).Should(MatchRegexp(`^"started" matched "started"\n    logged at .*logcap_test.go:\d+\n.*served.* matched 2 times, first "served 3"\n    logged at .*logcap_test.go:\d+\n$`))
+			h = HaveLogs("stopped", time.Millisecond*10)
+			Ω(logHook).ShouldNot(h)
+			Ω(h.Summary()).Should(Equal("\"stopped\" didn't match\n"))
+		})
+		It("applies fields after a Repeater to every repeat", func() {
+			for i := 0; i < 30; i++ {
+				logrus.WithField("batch", 7).Infof("Log entry %d", i)
+			}
+			h := HaveLogs(Repeater{MatchRegexp(`Log entry \d+`), 30}, logrus.Fields{"batch": 7})
+			Ω(len(h.(*logsMatcher).Matchers)).Should(Equal(30))
+			for _, match := range h.(*logsMatcher).Matchers {
+				Ω(*match.Fields).Should(Equal(logrus.Fields{"batch": 7}))
+			}
+			Ω(logHook).Should(h)
+			for i := 0; i < 30; i++ {
+				batch := 8
+				if i == 29 {
+					batch = 9
+				}
+				logrus.WithField("batch", batch).Infof("Log entry %d", i)
+			}
+			Ω(logHook).ShouldNot(HaveLogs(Repeater{MatchRegexp(`Log entry \d+`), 30}, logrus.Fields{"batch": 8}, time.Millisecond*50))
+			logHook.Reset()
+		})
+		It("skips logs that match nothing while waiting", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				logrus.Info("flushed second")
+				logrus.Info("noise")
+				time.Sleep(time.Millisecond * 20)
+				logrus.Info("flushed first")
+			}()
+			Ω(logHook).Should(HaveLogs("flushed first", "flushed second"))
+			<-done
+			h := HaveLogsStrict("flushed third", time.Second)
+			logrus.Info("more noise")
+			start := time.Now()
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(time.Since(start)).Should(BeNumerically("<", time.Millisecond*500))
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("noise"))
+			Ω(logHook).Should(HaveLogs("noise", "more noise"))
+		})
+		It("matches expected logs with noise interleaved between them", func() {
+			logrus.Info("noise one")
+			logrus.WithField("task", "other").Info("first")
+			logrus.WithField("task", "build").Info("first")
+			logrus.Warn("noise two")
+			logrus.WithField("task", "build").Info("second")
+			logrus.Info("noise three")
+			Ω(logHook).Should(HaveLogs("first", "second", logrus.Fields{"task": "build"}))
+			Ω(logHook).Should(HaveLogs("noise one", "first", "noise two", "noise three"))
+		})
+		It("keeps waiting past noise for a log that arrives later", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 5; i++ {
+					logrus.Infof("noise %d", i)
+					time.Sleep(time.Millisecond * 5)
+				}
+				logrus.Info("finally")
+			}()
+			Ω(logHook).Should(HaveLogs("finally"))
+			<-done
+			Ω(logHook).Should(HaveLogs("noise 0", "noise 1", "noise 2", "noise 3", "noise 4"))
+		})
+		It("checks fields across every log with AllLogsHaveFields", func() {
+			logrus.WithField("request_id", "r1").Info("started")
+			logrus.WithField("request_id", "r1").Info("working")
+			Ω(logHook).Should(AllLogsHaveFields(logrus.Fields{"request_id": Not(BeEmpty())}))
+			logrus.WithField("request_id", "").Warn("lost it")
+			logrus.Info("no id at all")
+			m := AllLogsHaveFields(logrus.Fields{"request_id": Not(BeEmpty())})
+			Ω(m.Match(logHook)).Should(BeFalse())
+			Ω(m.FailureMessage(logHook)).Should(MatchRegexp(`Instead, "lost it"\n    logged at .*logcap_test.go:\d+`))
+			Ω(logHook).Should(HaveLogs("started", "working", "lost it", "no id at all"))
+		})
+		It("redacts expected and actual fields in AllLogsHaveFields messages", func() {
+			logHook.RedactFields("token")
+			logrus.WithField("token", "s3cret-actual").Info("started")
+			m := AllLogsHaveFields(logrus.Fields{"token": "s3cret-expected"})
+			Ω(m.Match(logHook)).Should(BeFalse())
+			message := m.FailureMessage(logHook)
+			Ω(message).Should(ContainSubstring("have fields map[token:***]"))
+			Ω(message).Should(ContainSubstring("has fields map[token:***]"))
+			Ω(message).ShouldNot(ContainSubstring("s3cret"))
+			m = AllLogsHaveFields(logrus.Fields{"token": "s3cret-actual"})
+			Ω(m.Match(logHook)).Should(BeTrue())
+			Ω(m.NegatedFailureMessage(logHook)).ShouldNot(ContainSubstring("s3cret"))
+			Ω(logHook).Should(HaveLogs("started"))
+		})
+		It("checks required keys against registered field matchers", func() {
+			logHook.RegisterFieldMatcher("request_id", ValidUUID)
+			logrus.WithField("request_id", "not-a-uuid").Info("request started")
+			h := HaveLogs("request started", HaveFieldKeys("request_id"), time.Millisecond*50)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			Ω(h.FailureMessage(logHook)).Should(ContainSubstring("has keys [request_id] that fail their registered matchers"))
+			// An inline value takes precedence over the registered matcher.
+			Ω(logHook).Should(HaveLogs("request started", HaveFieldKeys("request_id"),
+				logrus.Fields{"request_id": "not-a-uuid"}))
+			logrus.WithField("request_id", "123e4567-e89b-12d3-a456-426614174000").Info("request started")
+			Ω(logHook).Should(HaveLogs("request started", HaveFieldKeys("request_id")))
+		})
+		It("lists every nonmatching log when a match fails", func() {
+			logrus.WithField("user", "ann").Info("stray one")
+			logrus.Info("wanted")
+			logrus.Warn("stray two")
+			logrus.Info("stray three")
+			h := HaveLogs("wanted", "missing", time.Millisecond*50)
+			Ω(h.Match(logHook)).Should(BeFalse())
+			message := h.FailureMessage(logHook)
+			Ω(message).Should(MatchRegexp(`(?s)stray one\\n    logged at .*logcap_test.go:\d+.*to equal.*missing`))
+			Ω(message).Should(MatchRegexp(`Other nonmatching logs:\n  stray two\n    logged at .*\n  stray three\n`))
+			Ω(logHook).Should(HaveLogs("stray one", "stray two", "stray three"))
+		})
 	})
 	Describe("with internal buffer", func() {
 		var (
@@ -137,6 +1492,29 @@ var _ = Describe("LogCap", func() {
 			ps.finish()
 			Ω(ps.s).Should(Equal("Failed to fire hook: internal buffer full, use a higher entryCount value\n"))
 		})
+		It("can block for room instead", func() {
+			logHook = NewLogHook(5, BlockOnFull(time.Second))
+			logHook.Start()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 50; i++ {
+					logrus.Info("burst")
+				}
+			}()
+			Ω(logHook).Should(HaveLogs(Repeater{"burst", 50}))
+			<-done
+		})
+		It("gives up blocking after the timeout", func() {
+			ps := newPipeSuck()
+			logHook = NewLogHook(1, BlockOnFull(time.Millisecond*10))
+			logHook.Start()
+			logrus.Info("fits")
+			logrus.Info("This one is too much")
+			ps.finish()
+			Ω(ps.s).Should(Equal("Failed to fire hook: internal buffer full for 10ms, use a higher entryCount value\n"))
+			Ω(logHook).Should(HaveLogs("fits"))
+		})
 	})
 	Describe("Display", func() {
 		var (
@@ -195,6 +1573,46 @@ var _ = Describe("LogCap", func() {
 			Ω(string(stderr)).Should(ContainSubstring(`level=warning msg="This the warning log"`))
 			Ω(string(stderr)).Should(ContainSubstring(`level=error msg="This the error log"`))
 		})
+		It("will display to a given writer", func() {
+			var buf bytes.Buffer
+			logHook.DisplayTo(&buf, logrus.WarnLevel)
+			logHook.Display(logrus.ErrorLevel)
+			logrus.Info("This the info log")
+			logrus.Warning("This the warning log")
+			logrus.Error("This the error log")
+			os.Stderr.Close()
+			stderr, _ := ioutil.ReadAll(r)
+			Ω(buf.String()).Should(ContainSubstring(`level=warning msg="This the warning log"`))
+			Ω(buf.String()).ShouldNot(ContainSubstring("This the info log"))
+			Ω(buf.String()).ShouldNot(ContainSubstring("This the error log"))
+			Ω(string(stderr)).Should(ContainSubstring(`level=error msg="This the error log"`))
+			Ω(string(stderr)).ShouldNot(ContainSubstring("This the warning log"))
+		})
+		It("will display from a level up", func() {
+			logHook.DisplayFrom(logrus.InfoLevel)
+			logrus.Debug("This the debug log")
+			logrus.Info("This the info log")
+			logrus.Error("This the error log")
+			os.Stderr.Close()
+			stderr, _ := ioutil.ReadAll(r)
+			Ω(string(stderr)).ShouldNot(ContainSubstring("This the debug log"))
+			Ω(string(stderr)).Should(ContainSubstring(`level=info msg="This the info log"`))
+			Ω(string(stderr)).Should(ContainSubstring(`level=error msg="This the error log"`))
+		})
+		It("will display entries picked by a predicate", func() {
+			logHook.DisplayWhere(func(e *logrus.Entry) bool {
+				return e.Data["component"] == "auth"
+			})
+			logHook.Display(logrus.ErrorLevel)
+			logrus.WithField("component", "auth").Debug("This the auth log")
+			logrus.WithField("component", "db").Info("This the db log")
+			logrus.Error("This the error log")
+			os.Stderr.Close()
+			stderr, _ := ioutil.ReadAll(r)
+			Ω(string(stderr)).Should(ContainSubstring(`level=debug msg="This the auth log"`))
+			Ω(string(stderr)).Should(ContainSubstring(`level=error msg="This the error log"`))
+			Ω(string(stderr)).ShouldNot(ContainSubstring("This the db log"))
+		})
 	})
 	Describe("Local loggers", func() {
 		var (
@@ -225,6 +1643,269 @@ var _ = Describe("LogCap", func() {
 			ps.finish()
 			Ω(ps.s).Should(Equal("Failed to fire hook: internal buffer full, use a higher entryCount value\n"))
 		})
+		It("puts a custom ExitFunc back after catching an exit", func() {
+			exited := 0
+			local.ExitFunc = func(code int) { exited = code }
+			code, caught := hook.CatchExit(func() {
+				local.Fatal("giving up")
+			})
+			Ω(caught).Should(BeTrue())
+			Ω(code).Should(Equal(1))
+			Ω(exited).Should(BeZero())
+			Ω(hook).Should(HaveLogs("giving up", logrus.FatalLevel))
+			hook.Stop()
+			local.ExitFunc(3)
+			Ω(exited).Should(Equal(3))
+		})
+		It("keeps an ExitFunc set after Start", func() {
+			hook.Stop()
+			local.ExitFunc = func(int) { Fail("the original ExitFunc was put back") }
+			hook = NewLogHook(local)
+			hook.Start()
+			exited := 0
+			local.ExitFunc = func(code int) { exited = code }
+			hook.Stop()
+			local.ExitFunc(4)
+			Ω(exited).Should(Equal(4))
+		})
+		It("truncates huge field values", func() {
+			hook.Stop()
+			hook = NewLogHook(local, MaxFieldBytes(8))
+			hook.Start()
+			local.WithFields(logrus.Fields{
+				"body":  strings.Repeat("x", 1<<20),
+				"raw":   []byte("0123456789"),
+				"short": "fits",
+				"count": 1 << 20,
+			}).Info("huge")
+			Ω(hook).Should(HaveLogs("huge", logrus.Fields{
+				"body":  "xxxxxxxx...[truncated]",
+				"raw":   "01234567...[truncated]",
+				"short": "fits",
+				"count": 1 << 20,
+			}))
+		})
+		It("truncates on a character boundary", func() {
+			hook.Stop()
+			hook = NewLogHook(local, MaxFieldBytes(8))
+			hook.Start()
+			local.WithField("price", "abcdefg€uro").Info("priced") // € takes bytes 7 to 9
+			entries := hook.Entries()
+			Ω(entries).Should(HaveLen(1))
+			Ω(utf8.ValidString(entries[0].Data["price"].(string))).Should(BeTrue())
+			Ω(hook).Should(HaveLogs("priced", logrus.Fields{"price": "abcdefg...[truncated]"}))
+		})
+		It("keeps the application's file and line fields by default", func() {
+			local.WithFields(logrus.Fields{"file": "app.go", "line": "ten"}).Info("mine")
+			Ω(hook).Should(HaveLogs("mine", logrus.Fields{"file": "app.go", "line": "ten"}))
+		})
+		It("keeps the application's file field and records the line with CollisionPrefer", func() {
+			hook.Stop()
+			hook = NewLogHook(local, OnKeyCollision(CollisionPrefer))
+			hook.Start()
+			local.WithField("file", "app.go").Info("mine")
+			Ω(hook).Should(HaveLogs("mine", logrus.Fields{"file": "app.go", "line": BeNumerically(">", 0)}))
+		})
+		It("records no call site over the application's file field with CollisionSkip", func() {
+			hook.Stop()
+			hook = NewLogHook(local, OnKeyCollision(CollisionSkip))
+			hook.Start()
+			local.WithField("file", "app.go").Info("mine")
+			Ω(hook).Should(HaveLogs("mine", logrus.Fields{"file": "app.go", "line": Absent}))
+		})
+		It("refuses entries with file and line fields with CollisionError", func() {
+			hook.Stop()
+			hook = NewLogHook(local, OnKeyCollision(CollisionError))
+			hook.Start()
+			ps := newPipeSuck()
+			local.WithField("line", 7).Info("mine")
+			local.Info("fine")
+			ps.finish()
+			Ω(ps.s).Should(Equal("Failed to fire hook: entry already has a \"line\" field\n"))
+			Ω(hook).Should(HaveLogs("fine"))
+			Ω(hook).Should(HaveNoLogs())
+		})
+		It("passes captured entries on to Tee hooks", func() {
+			hook.Stop()
+			counter := &countingHook{}
+			hook = NewLogHook(local, Tee(counter))
+			hook.Start()
+			local.Info("one")
+			local.Warn("two")
+			Ω(hook).Should(HaveLogs("one", "two"))
+			Ω(counter.count()).Should(Equal(2))
+			Ω(hook.TeeCount()).Should(Equal(2))
+		})
+		It("doesn't tee entries it fails to capture", func() {
+			hook.Stop()
+			counter := &countingHook{}
+			hook = NewLogHook(local, 2, Tee(counter))
+			hook.Start()
+			ps := newPipeSuck()
+			local.Info("one")
+			local.Info("two")
+			local.Info("three")
+			ps.finish()
+			Ω(ps.s).Should(Equal("Failed to fire hook: internal buffer full, use a higher entryCount value\n"))
+			Ω(hook.EntriesWithSource()).Should(HaveLen(2))
+			Ω(hook.TeeCount()).Should(Equal(2))
+			Ω(counter.count()).Should(Equal(2))
+			Ω(hook).Should(HaveLogs("one", "two"))
+		})
+		It("shows the stack of unexpected logs with CaptureStack", func() {
+			hook.Stop()
+			hook = NewLogHook(local, CaptureStack())
+			hook.Start()
+			logFromHelper(local, "unexpected")
+			h := HaveLogs("expected", time.Millisecond*100)
+			Ω(h.Match(hook)).Should(BeFalse())
+			message := h.FailureMessage(hook)
+			Ω(message).Should(MatchRegexp(`stack:\\n +\S+logFromHelper \S+logcap_test.go:\d+\\n`))
+			Ω(message).ShouldNot(ContainSubstring("sirupsen/logrus"))
+			Ω(hook).Should(HaveLogs("unexpected"))
+		})
+		It("leaves other hooks in place with PreserveHooks", func() {
+			hook.Stop()
+			counter := &countingHook{}
+			local.AddHook(counter)
+			hook = NewLogHook(local, PreserveHooks())
+			hook.Start()
+			local.Info("both see this")
+			Ω(hook).Should(HaveLogs("both see this"))
+			Ω(counter.count()).Should(Equal(1))
+			hook.Stop()
+			local.Info("only the app hook sees this")
+			Ω(counter.count()).Should(Equal(2))
+			Ω(local.Hooks[logrus.InfoLevel]).Should(Equal([]logrus.Hook{counter}))
+			Ω(hook).Should(HaveNoLogs())
+		})
+		It("captures from several loggers at once", func() {
+			hook.Stop()
+			other := logrus.New()
+			hook = NewLogHook(local, other)
+			hook.Start()
+			local.Info("from local")
+			other.WithField("svc", "other").Warning("from other")
+			Ω(hook).Should(HaveLogs("from local", logrus.Fields{}, "from other", logrus.Fields{"svc": "other"}))
+			hook.Stop()
+			Ω(other.Hooks).Should(BeEmpty())
+			Ω(local.Hooks).Should(BeEmpty())
+			other.Info("after stop")
+			Ω(hook.Count()).Should(Equal(2))
+			hook.Start()
+		})
+		It("records which logger an entry came from", func() {
+			hook.Stop()
+			other := logrus.New()
+			hook = NewLogHook(local, other, NameLogger(local, "local"), NameLogger(other, "other"))
+			hook.Start()
+			local.Info("hello")
+			other.Info("hello")
+			other.WithField("logger", "app").Info("own field")
+			Ω(hook).Should(HaveLogs(
+				"hello", logrus.Fields{"logger": "other"},
+				"hello", logrus.Fields{"logger": "local"},
+				"own field", logrus.Fields{"logger": "app"},
+			))
+			other.Info("unexpected")
+			h := HaveLogs("expected", time.Millisecond*50)
+			Ω(h.Match(hook)).Should(BeFalse())
+			Ω(h.FailureMessage(hook)).Should(ContainSubstring(`logrus.Fields{\"logger\":\"other\"}`))
+			Ω(hook).Should(HaveLogs("unexpected", logrus.Fields{"logger": "other"}))
+		})
+		It("saves and restores logger state", func() {
+			hook.Stop()
+			var out bytes.Buffer
+			local.SetOutput(&out)
+			local.SetLevel(logrus.WarnLevel)
+			local.SetFormatter(&logrus.JSONFormatter{})
+			local.AddHook(&countingHook{})
+			func() {
+				defer func() { recover() }()
+				defer SaveLogrusState(local)()
+				local.SetLevel(logrus.TraceLevel)
+				local.SetFormatter(&logrus.TextFormatter{})
+				h := NewLogHook(local)
+				h.Start()
+				panic("skips the cleanup")
+			}()
+			Ω(local.GetLevel()).Should(Equal(logrus.WarnLevel))
+			Ω(local.Out).Should(BeIdenticalTo(&out))
+			Ω(local.Formatter).Should(BeAssignableToTypeOf(&logrus.JSONFormatter{}))
+			Ω(local.Hooks[logrus.InfoLevel]).Should(HaveLen(1))
+			Ω(local.Hooks[logrus.InfoLevel][0]).Should(BeAssignableToTypeOf(&countingHook{}))
+			hook.Start()
+		})
+		It("uses the caller Logrus reports", func() {
+			local.SetReportCaller(true)
+			local.Info("reported")
+			_, file, line, _ := runtime.Caller(0)
+			records := hook.EntriesWithSource()
+			Ω(records).Should(HaveLen(1))
+			Ω(records[0].Source).Should(Equal(fmt.Sprintf("%s:%d", file, line-1)))
+			h := HaveLogs("something else", time.Millisecond*50)
+			Ω(h.Match(hook)).Should(BeFalse())
+			Ω(h.FailureMessage(hook)).Should(MatchRegexp(`logged at .*logcap_test.go:\d+ \(github.com/allenluce/logcap\.`))
+			Ω(hook).Should(HaveLogs("reported"))
+		})
+		It("shows the reported caller of error logs without a stack", func() {
+			local.SetReportCaller(true)
+			local.Error("failed without a trace")
+			h := HaveErrorLogsWithStack("stack")
+			Ω(h.Match(hook)).Should(BeFalse())
+			Ω(h.FailureMessage(hook)).Should(MatchRegexp(`logged at .*logcap_test.go:\d+ \(github.com/allenluce/logcap\.`))
+			Ω(hook).Should(HaveLogs("failed without a trace"))
+		})
+		It("skips a reported caller in an ignored file", func() {
+			local.SetReportCaller(true)
+			hook.IgnoreCaller("logcap_test.go")
+			logFromHelper(local, "from helper")
+			records := hook.EntriesWithSource()
+			Ω(records).Should(HaveLen(1))
+			Ω(records[0].Source).ShouldNot(ContainSubstring("logcap_test.go"))
+			Ω(hook).Should(HaveLogs("from helper"))
+		})
+	})
+	Describe("AwaitLog", func() {
+		var logHook *LogCap
+		BeforeEach(func() {
+			logHook = NewLogHook()
+			logHook.Start()
+		})
+		AfterEach(func() {
+			logHook.Stop()
+		})
+		It("coordinates a goroutine off an awaited log", func() {
+			proceed := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				logrus.Info("worker ready")
+				<-proceed
+				logrus.Info("worker finished")
+			}()
+			entry := <-logHook.AwaitLog("worker ready")
+			Ω(entry).ShouldNot(BeNil())
+			Ω(entry.Message).Should(Equal("worker ready"))
+			close(proceed)
+			<-done
+			Ω(logHook).Should(HaveLogs("worker ready", "worker finished"))
+		})
+		It("accepts Gomega matchers", func() {
+			logrus.Info("connection 42 open")
+			entry := <-logHook.AwaitLog(MatchRegexp(`connection \d+ open`))
+			Ω(entry).ShouldNot(BeNil())
+			Ω(logHook).Should(HaveLogs("connection 42 open"))
+		})
+		It("closes the channel on timeout", func() {
+			Eventually(logHook.AwaitLog("never logged", time.Millisecond*50)).Should(BeClosed())
+		})
+		It("closes the channel when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			ch := logHook.AwaitLog("never logged", ctx)
+			cancel()
+			Eventually(ch, time.Millisecond*500).Should(BeClosed())
+		})
 	})
 })
 
@@ -280,3 +1961,64 @@ func (p *pipeSuck) finish() {
 	os.Stderr = p.oldStderr
 	p.r.Close()
 }
+
+// escalateHook turns warnings into errors.
+type escalateHook struct{}
+
+func (escalateHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel}
+}
+
+func (escalateHook) Fire(e *logrus.Entry) error {
+	e.Level = logrus.ErrorLevel
+	return nil
+}
+
+// slowEscalateHook escalates warnings to errors after a pause, so an
+// early look at the entry would still see a warning.
+type slowEscalateHook struct{}
+
+func (slowEscalateHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel}
+}
+
+func (slowEscalateHook) Fire(e *logrus.Entry) error {
+	time.Sleep(time.Millisecond * 20)
+	e.Level = logrus.ErrorLevel
+	return nil
+}
+
+// connectionStates are the allowed transitions of a connection.
+var connectionStates = map[string][]string{
+	"idle":       {"connecting"},
+	"connecting": {"connected", "idle"},
+	"connected":  {"idle"},
+}
+
+// countingHook counts the entries fired at it.
+type countingHook struct {
+	mut sync.Mutex
+	n   int
+}
+
+func (h *countingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *countingHook) Fire(e *logrus.Entry) error {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.n++
+	return nil
+}
+
+func (h *countingHook) count() int {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return h.n
+}
+
+// logFromHelper logs from one call deeper, to show up in a stack.
+func logFromHelper(logger *logrus.Logger, message string) {
+	logger.Info(message)
+}
@@ -1,7 +1,12 @@
 package logcap
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,38 +17,218 @@ import (
 
 var logMut sync.Mutex
 
+var (
+	timeoutMut  sync.Mutex
+	baseTimeout = time.Second * 2 // How long matchers wait for logs to show up
+)
+
+// SetDefaultTimeout sets how long matchers made from now on wait for
+// logs to show up, in place of two seconds. Call it once, in a
+// BeforeSuite() say, rather than passing a time.Duration to every
+// HaveLogs(). A time.Duration argument still overrides it.
+func SetDefaultTimeout(d time.Duration) {
+	timeoutMut.Lock()
+	defer timeoutMut.Unlock()
+	baseTimeout = d
+}
+
+// defaultTimeout returns the timeout set with SetDefaultTimeout().
+func defaultTimeout() time.Duration {
+	timeoutMut.Lock()
+	defer timeoutMut.Unlock()
+	return baseTimeout
+}
+
+var (
+	budgetMut sync.Mutex
+	budgetEnd time.Time // When the suite's time budget runs out
+)
+
+// SetTimeoutBudget gives the suite an overall time budget, starting
+// now, that Budget() timeouts are taken from. A zero total removes the
+// budget.
+func SetTimeoutBudget(total time.Duration) {
+	budgetMut.Lock()
+	defer budgetMut.Unlock()
+	budgetEnd = time.Time{}
+	if total > 0 {
+		budgetEnd = time.Now().Add(total)
+	}
+}
+
+// Budget is a timeout given as a fraction of what's left of the time
+// budget set with SetTimeoutBudget(). Passed to HaveLogs() in place of
+// a time.Duration, it keeps many assertions within an overall
+// deadline:
+//
+//   logcap.SetTimeoutBudget(time.Minute)
+//   ...
+//   Ω(logHook).Should(HaveLogs("done", logcap.Budget(0.1))) // 10% of what's left
+//
+// The fraction is taken when the matcher is made. Without a budget,
+// the default timeout is used. Once the budget is spent, only logs
+// that have already arrived can match.
+type Budget float64
+
+// timeout works out the timeout a Budget allows now.
+func (b Budget) timeout() time.Duration {
+	budgetMut.Lock()
+	defer budgetMut.Unlock()
+	if budgetEnd.IsZero() {
+		return defaultTimeout()
+	}
+	remaining := time.Until(budgetEnd)
+	if remaining < 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) * float64(b))
+}
+
 // Repeater allows for easy repeating of log matches. If you have something that's going to log
 // 30 times, just use a repeater:
 //
 //    Ω(logHook).Should(HaveLogs(Repeater{MatchRegexp(`Log entry \d+`), 30}))
 //
+// A logrus.Fields{} (or level, or other field-spec) argument after a
+// Repeater applies to every one of the repeated matches, just as it
+// would to the same string written out 30 times:
+//
+//    Ω(logHook).Should(HaveLogs(Repeater{MatchRegexp(`Log entry \d+`), 30}, logrus.Fields{"batch": 7}))
+//
 type Repeater struct {
 	M interface{}
 	N int
 }
 
+// RepeaterRange is like Repeater but allows a range of counts. It
+// expects at least Min entries matching M and no more than Max. A Max
+// of 0 means there's no upper limit:
+//
+//    Ω(logHook).Should(HaveLogs(RepeaterRange{"retrying", 3, 10}))
+//
+// Entries past Max that have been logged by the time the other
+// expectations are met make the match fail.
+type RepeaterRange struct {
+	M   interface{}
+	Min int
+	Max int
+}
+
 type markedEntry struct {
 	*logrus.Entry
 	matched bool
+	seq     uint64
+	stack   []string // Call stack, with CaptureStack()
+	routine int64    // ID of the goroutine that logged it
+	caller  string   // Function that logged it, with SetReportCaller()
 }
 
 type logsMatch struct {
 	Expected types.GomegaMatcher
 	matched  bool
 	Fields   *logrus.Fields
+	exact    bool // From ExactFields, no other fields allowed
+	Keys     FieldKeys
+	Ignore   IgnoredFields
+	Level    *logrus.Level
 	Entry    *markedEntry
+	prefix   string // Required message prefix, stripped before matching
+	ranged   bool   // From a RepeaterRange, matches min to max entries
+	min, max int
+	count    int          // Entries matched by a ranged expectation
+	window   TimeWindow
+	nearMiss *markedEntry                   // Last entry whose message matched but fields or level didn't
+	valueFor map[string]types.GomegaMatcher // The hook's RegisterFieldMatcher() matchers
 }
 
+// ExactFields works like a logrus.Fields{} argument to HaveLogs() but
+// also fails if the entry has any field it doesn't mention, so stray
+// fields can't creep into a log unnoticed:
+//
+//   HaveLogs("login", logcap.ExactFields{"user": "ann", "method": "sso"})
+//
+// The file and line of the call site don't count, but fields added by
+// TagEntries() or NameLogger() do. Keys named with
+// HaveLogsIgnoringFields() are allowed either way.
+type ExactFields logrus.Fields
+
+// FieldKeys is a field-spec that requires a set of keys to be
+// present in an entry's fields, whatever their values. See
+// HaveFieldKeys().
+type FieldKeys []string
+
+// LevelString is a level given by name ("warning", "error" and so on)
+// as an argument to HaveLogs(). It works just like a logrus.Level
+// argument, for tests that only know levels as strings (from config,
+// say). A name logrus.ParseLevel() doesn't know makes the match fail
+// with an error.
+type LevelString string
+
+// TimeWindow is a field-spec requiring entries to have been logged
+// recently. See WithinLast().
+type TimeWindow struct {
+	d time.Duration
+}
+
+// IgnoredFields is a field-spec naming keys to leave out of field
+// matching. See HaveLogsIgnoringFields().
+type IgnoredFields []string
+
 type logsMatcher struct {
 	Matchers    []*logsMatch
-	NonMatching *markedEntry
+	NonMatching []*markedEntry // Entries that matched no expectation, in order
 	timeout     time.Duration
+	accept      func(*markedEntry) bool
+	span        string // Only look between Begin() and End() of this span
+	noWait      bool   // Only look at what's already been logged
+	strict      bool   // Fail on the first entry that matches nothing
+	ctx         context.Context
+	hook        *LogCap
+	ordered     bool
+	err         error        // Bad argument, reported by Match
+	outOfOrder  *markedEntry // Entry that matched ahead of its turn
+	skipped     *logsMatch   // Expectation it jumped ahead of
+
+	overflow      *logsMatch   // Ranged expectation that matched too many
+	overflowEntry *markedEntry // The first entry past its limit
+}
+
+// LogsMatcher is the Gomega matcher returned by HaveLogs() and its
+// variants. After a match it can hand back the entries that satisfied
+// each expectation.
+type LogsMatcher interface {
+	types.GomegaMatcher
+	// MatchedEntries returns one entry per expectation, in the order
+	// the expectations were given (with Repeaters expanded). An
+	// expectation that didn't match has a nil entry, so the slice
+	// always lines up with the arguments. Call it after Match(), or
+	// after Ω(...).Should() has used the matcher.
+	MatchedEntries() []*logrus.Entry
+	// Unmatched describes the expectations that didn't match and
+	// returns the captured entries that nothing has matched yet, in
+	// the order they were logged. Call it after a failed Match() to
+	// get at what went wrong without parsing the failure message.
+	Unmatched() (expectations []string, entries []*logrus.Entry)
+	// Timeout returns how long Match() waits for logs, after any
+	// time.Duration or Budget argument and SetDefaultTimeout().
+	Timeout() time.Duration
+	// Summary describes which entry each expectation matched and
+	// where it was logged, one expectation per line, to audit a
+	// passing assertion:
+	//
+	//   h := HaveLogs("started", MatchRegexp(`^served \d+`))
+	//   Ω(logHook).Should(h)
+	//   GinkgoWriter.Write([]byte(h.Summary()))
+	Summary() string
 }
 
 type noLogsMatcher struct {
 	matchers.EqualMatcher
-	level *logrus.Level
-	found int
+	level     *logrus.Level
+	atOrAbove bool // Count entries at level or more severe, not just at level
+	found     int
+
+	includeMatched bool // Count entries HaveLogs() already matched
 }
 
 // HaveLogs takes a number of strings, Gomega matchers and/or
@@ -52,7 +237,11 @@ type noLogsMatcher struct {
 // is applied to all strings/matchers that precede it up until the
 // previous logrus.Fields{} argument.  To succeed, all
 // strings/matchers must match along with their associated
-// logrus.Fields{} argument.
+// logrus.Fields{} argument. Logs that none of the strings/matchers
+// match are skipped, so unrelated or slightly reordered logs don't get
+// in the way; if the expectations still aren't met when the timeout
+// runs out, the failure message lists the logs that were skipped.
+// HaveLogsStrict() fails at the first of them instead.
 //
 // This matches three distinct log entries, each with a {"task":
 // "exiting"} field set:
@@ -64,18 +253,205 @@ type noLogsMatcher struct {
 //
 //   HaveLogs("alpha", "beta", logrus.Fields{}, "gamma", logrus.Fields{"big": "whoop"})
 //
+// A logrus.Level argument works the same way as logrus.Fields{},
+// requiring the entries matched by the preceding strings/matchers to
+// be logged at that level:
+//
+//   HaveLogs("disk full", logrus.ErrorLevel)
+//
 // An optional time.Duration added to the arguments will set the
-// timeout for HaveLogs giving up on waiting for a match.
+// timeout for HaveLogs giving up on waiting for a match. The timeout
+// covers the whole match, however many other logs arrive meanwhile.
 //
 //   HaveLogs("summation", time.Seconds*100)
 //
-// The default timeout is two seconds.
-func HaveLogs(args ...interface{}) types.GomegaMatcher {
-	m := &logsMatcher{timeout: time.Second * 2}
+// The default timeout is two seconds, or whatever was set with
+// SetDefaultTimeout(). A context.Context argument stops the wait early
+// when it's canceled, so a spec with a deadline doesn't sit out the
+// timeout:
+//
+//   HaveLogs("summation", ctx)
+//
+// Field values are compared with Gomega's Equal() unless a matcher is
+// given. If the expected value is itself a logrus.Fields{} (or a
+// map[string]interface{}), the field must hold a map and its keys are
+// matched the same way, so nested values can be checked:
+//
+//   HaveLogs("handled", logrus.Fields{"req": logrus.Fields{"method": "GET"}})
+//
+// Keys in the nested map that aren't mentioned are ignored. If one
+// side is a pointer and the other isn't, the pointer is followed
+// first, so logrus.Fields{"count": 3} matches an entry whose "count"
+// field holds an *int pointing at 3. Nil pointers never match a value.
+//
+// A MatchError() matcher also works on an error that has been turned
+// into a string, comparing the string with the expected error's
+// message, so this matches whether or not the "error" field set by
+// logrus.WithError() was stringified along the way:
+//
+//   HaveLogs("failed", logrus.Fields{"error": MatchError(io.EOF)})
+func HaveLogs(args ...interface{}) LogsMatcher {
+	m := &logsMatcher{timeout: defaultTimeout()}
+	parseMatchArgs(args, m)
+	return m
+}
+
+// HaveLogsNow works like HaveLogs() but doesn't wait: it looks only at
+// what has been logged by the time it runs and fails at once if that
+// isn't enough. Use it when the code under test logs synchronously, so
+// a failing assertion doesn't sit out the timeout:
+//
+//   doWork()
+//   Ω(logHook).Should(HaveLogsNow("work done"))
+//
+// A time.Duration argument is ignored.
+func HaveLogsNow(args ...interface{}) LogsMatcher {
+	m := &logsMatcher{noWait: true}
+	parseMatchArgs(args, m)
+	return m
+}
+
+// HaveLogsStrict works like HaveLogs() but fails as soon as it comes
+// across an entry that none of the expectations match, rather than
+// skipping it and waiting for the expected logs. Use it when nothing
+// else should be logged in the meantime:
+//
+//   Ω(logHook).Should(HaveLogsStrict("connecting", "connected"))
+func HaveLogsStrict(args ...interface{}) LogsMatcher {
+	m := &logsMatcher{timeout: defaultTimeout(), strict: true}
 	parseMatchArgs(args, m)
 	return m
 }
 
+// HaveLogsInOrder works like HaveLogs() but also requires the
+// strings/matchers to match entries in the order they were logged.
+// Other logs may come in between. If an entry matches an expectation
+// before the ones ahead of it have been found, the match fails at
+// once and the failure message points at the entry that was out of
+// sequence:
+//
+//   Ω(logHook).Should(HaveLogsInOrder("connecting", "connected"))
+func HaveLogsInOrder(args ...interface{}) LogsMatcher {
+	m := &logsMatcher{timeout: defaultTimeout(), ordered: true}
+	parseMatchArgs(args, m)
+	return m
+}
+
+// HaveLogsAtLevelRange works like HaveLogs() but only considers
+// entries whose level falls within the inclusive range given by min
+// and max. Entries outside the range are ignored entirely. Since
+// Logrus numbers its levels backwards (PanicLevel is 0), the bounds
+// may be given in either order:
+//
+//   HaveLogsAtLevelRange(logrus.InfoLevel, logrus.WarnLevel, "disk low")
+//
+// matches "disk low" logged at either info or warning level.
+func HaveLogsAtLevelRange(min, max logrus.Level, args ...interface{}) LogsMatcher {
+	if min > max {
+		min, max = max, min
+	}
+	m := &logsMatcher{
+		timeout: defaultTimeout(),
+		accept: func(e *markedEntry) bool {
+			return e.Level >= min && e.Level <= max
+		},
+	}
+	parseMatchArgs(args, m)
+	return m
+}
+
+// HaveLogsBetween works like HaveLogs() but only considers entries
+// logged between the hook's Begin(name) and End(name) calls. Entries
+// logged outside the span are ignored entirely. If End(name) hasn't
+// been called yet, the span is still open and takes everything logged
+// since Begin(name):
+//
+//   logHook.Begin("tx")
+//   store.Commit()
+//   logHook.End("tx")
+//   Ω(logHook).Should(HaveLogsBetween("tx", "committed"))
+func HaveLogsBetween(name string, args ...interface{}) LogsMatcher {
+	m := &logsMatcher{timeout: defaultTimeout(), span: name}
+	parseMatchArgs(args, m)
+	return m
+}
+
+// HaveLogsWithPrefix works like HaveLogs() for logs from a wrapper
+// that starts every message with the same prefix. The prefix is
+// stripped from each message before it's compared, so the
+// expectations don't have to repeat it:
+//
+//   Ω(logHook).Should(HaveLogsWithPrefix("[api] ", "listening", MatchRegexp(`^served \d+`)))
+//
+// Entries whose message doesn't start with the prefix don't match.
+func HaveLogsWithPrefix(prefix string, args ...interface{}) LogsMatcher {
+	m := &logsMatcher{timeout: defaultTimeout()}
+	parseMatchArgs(args, m)
+	for _, match := range m.Matchers {
+		match.prefix = prefix
+	}
+	return m
+}
+
+// HaveLogfMatch renders the expected message with fmt.Sprintf() and
+// matches it exactly, so a test can use the same format as the code
+// that logs:
+//
+//   logrus.Infof("took %.2fs", elapsed)
+//   Ω(logHook).Should(HaveLogfMatch("took %.2fs", elapsed))
+//
+// Use HaveLogs() with the rendered string to add fields, levels or a
+// timeout.
+func HaveLogfMatch(format string, args ...interface{}) LogsMatcher {
+	return HaveLogs(fmt.Sprintf(format, args...))
+}
+
+// HaveFieldKeys returns a field-spec that matches entries carrying
+// all of the given keys regardless of their values. Like a
+// logrus.Fields{} argument, it applies to all strings/matchers that
+// precede it in HaveLogs():
+//
+//   HaveLogs("request handled", HaveFieldKeys("method", "path", "status"))
+//
+// It can be combined with a logrus.Fields{} argument to check values
+// for some keys and only presence for others. Keys with a matcher from
+// RegisterFieldMatcher() must also have values it matches.
+func HaveFieldKeys(keys ...string) FieldKeys {
+	return FieldKeys(keys)
+}
+
+// WithinLast returns a field-spec requiring entries to have been
+// logged no more than d before the match, going by the entries' Time.
+// This catches stale or replayed events. Like a logrus.Fields{}
+// argument, it applies to all strings/matchers that precede it in
+// HaveLogs():
+//
+//   HaveLogs("heartbeat", WithinLast(time.Second*5))
+func WithinLast(d time.Duration) TimeWindow {
+	return TimeWindow{d: d}
+}
+
+// contains reports whether t falls within the window, counting back
+// from now.
+func (w TimeWindow) contains(t time.Time) bool {
+	return w.d == 0 || time.Since(t) <= w.d
+}
+
+// HaveLogsIgnoringFields returns a field-spec that excludes the given
+// keys from field matching: they are neither required to be present
+// nor compared, even if a logrus.Fields{} or HaveFieldKeys() argument
+// mentions them. This lets a shared set of expected fields be reused
+// when some values (timestamps, durations) vary from run to run:
+//
+//   expected := logrus.Fields{"status": "ok", "duration": 0}
+//   HaveLogs("request done", expected, HaveLogsIgnoringFields("duration"))
+//
+// Like a logrus.Fields{} argument, it applies to all strings/matchers
+// that precede it.
+func HaveLogsIgnoringFields(keys ...string) IgnoredFields {
+	return IgnoredFields(keys)
+}
+
 // HaveNoLogs is the inverse of HaveLogs(). It makes sure that there
 // are no logs that haven't been matched already.
 //
@@ -93,6 +469,60 @@ func HaveNoLogs(level ...logrus.Level) types.GomegaMatcher {
 	return m
 }
 
+// HaveNoLogsAbove is like HaveNoLogs(level) but treats the level as a
+// floor: it makes sure there are no unmatched logs at that level or
+// any more severe one. Since Logrus numbers its levels backwards
+// (PanicLevel is 0), that's every level numerically at or below the
+// given one. To allow info and debug logs but no warnings or worse:
+//
+//  Ω(logHook).Should(HaveNoLogsAbove(logrus.WarnLevel))
+func HaveNoLogsAbove(level logrus.Level) types.GomegaMatcher {
+	return &noLogsMatcher{
+		EqualMatcher: matchers.EqualMatcher{Expected: 0},
+		level:        &level,
+		atOrAbove:    true,
+	}
+}
+
+// HaveNoLogsAtOrAbove makes sure no captured entry is at the given
+// level or any more severe one, listing any that are. This enforces
+// the common policy of allowing info and debug logs but no warnings or
+// errors:
+//
+//  Ω(logHook).Should(HaveNoLogsAtOrAbove(logrus.WarnLevel))
+//
+// Entries already matched by HaveLogs() count too, so a test can't
+// excuse a warning by matching it. Pass false to leave them out, which
+// makes it the same as HaveNoLogsAbove():
+//
+//  Ω(logHook).Should(HaveNoLogsAtOrAbove(logrus.WarnLevel, false))
+func HaveNoLogsAtOrAbove(level logrus.Level, includeMatched ...bool) types.GomegaMatcher {
+	m := &noLogsMatcher{
+		EqualMatcher:   matchers.EqualMatcher{Expected: 0},
+		level:          &level,
+		atOrAbove:      true,
+		includeMatched: true,
+	}
+	if len(includeMatched) > 0 {
+		m.includeMatched = includeMatched[0]
+	}
+	return m
+}
+
+// counts reports whether an entry is one the matcher looks for.
+func (m *noLogsMatcher) counts(entry *markedEntry) bool {
+	if entry.matched && !m.includeMatched {
+		return false
+	}
+	switch {
+	case m.level == nil:
+		return true
+	case m.atOrAbove:
+		return entry.Level <= *m.level
+	}
+	return entry.Level == *m.level
+}
+
 // matcherOrEqual if given a matcher will use it. Otherwise it'll use
 // the stock EqualMatcher.
 func matcherOrEqual(arg interface{}) *logsMatch {
@@ -114,18 +544,417 @@ func parseMatchArgs(args []interface{}, m *logsMatcher) {
 				}
 				m.Matchers[i].Fields = &arg
 			}
+		case ExactFields:
+			fields := logrus.Fields(arg)
+			for i := len(m.Matchers) - 1; i >= 0; i-- {
+				if m.Matchers[i].Fields != nil {
+					break
+				}
+				m.Matchers[i].Fields = &fields
+				m.Matchers[i].exact = true
+			}
+		case FieldKeys:
+			for i := len(m.Matchers) - 1; i >= 0; i-- {
+				if m.Matchers[i].Keys != nil {
+					break
+				}
+				m.Matchers[i].Keys = arg
+			}
+		case logrus.Level:
+			m.setLevel(arg)
+		case LevelString:
+			level, err := logrus.ParseLevel(string(arg))
+			if err != nil {
+				m.err = err
+				continue
+			}
+			m.setLevel(level)
+		case TimeWindow:
+			for i := len(m.Matchers) - 1; i >= 0; i-- {
+				if m.Matchers[i].window.d != 0 {
+					break
+				}
+				m.Matchers[i].window = arg
+			}
+		case IgnoredFields:
+			for i := len(m.Matchers) - 1; i >= 0; i-- {
+				if m.Matchers[i].Ignore != nil {
+					break
+				}
+				m.Matchers[i].Ignore = arg
+			}
 		case Repeater:
 			for i := 0; i < arg.N; i++ {
 				m.Matchers = append(m.Matchers, matcherOrEqual(arg.M))
 			}
+		case RepeaterRange:
+			match := matcherOrEqual(arg.M)
+			match.ranged, match.min, match.max = true, arg.Min, arg.Max
+			m.Matchers = append(m.Matchers, match)
 		case time.Duration:
 			m.timeout = arg
+		case context.Context:
+			m.ctx = arg
+		case Budget:
+			m.timeout = arg.timeout()
 		default:
 			m.Matchers = append(m.Matchers, matcherOrEqual(arg))
 		}
 	}
 }
 
+// missing returns the keys not present in data.
+func (k FieldKeys) missing(data logrus.Fields) (missing []string) {
+	for _, key := range k {
+		if _, ok := data[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return
+}
+
+// has reports whether key is one of the ignored keys.
+func (i IgnoredFields) has(key string) bool {
+	for _, k := range i {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// absentField is the type of Absent.
+type absentField struct{}
+
+// Absent is a field value that requires the key to be missing from an
+// entry altogether. Use it in a logrus.Fields{} argument to make sure
+// something didn't leak into the logs, alongside the usual checks:
+//
+//   HaveLogs("login", logrus.Fields{"user": "ann", "password": logcap.Absent})
+var Absent = absentField{}
+
+// presentKeys returns the keys required to be Absent that are in
+// data anyway.
+func (match *logsMatch) presentKeys(data logrus.Fields) (present []string) {
+	if match.Fields == nil {
+		return
+	}
+	for key, value := range *match.Fields {
+		if _, absent := value.(absentField); !absent || match.Ignore.has(key) {
+			continue
+		}
+		if _, ok := data[key]; ok {
+			present = append(present, key)
+		}
+	}
+	sort.Strings(present)
+	return
+}
+
+// missingKeys returns the required keys not present in data, leaving
+// out ignored keys.
+func (match *logsMatch) missingKeys(data logrus.Fields) (missing []string) {
+	for _, key := range match.Keys.missing(data) {
+		if !match.Ignore.has(key) {
+			missing = append(missing, key)
+		}
+	}
+	return
+}
+
+// invalidKeys returns the required keys whose values in data fail the
+// matchers registered for them, leaving out keys with an expected
+// value of their own and ignored keys.
+func (match *logsMatch) invalidKeys(data logrus.Fields) (invalid []string, err error) {
+	for _, key := range match.Keys {
+		matcher, ok := match.valueFor[key]
+		if !ok || match.Ignore.has(key) {
+			continue
+		}
+		if match.Fields != nil {
+			if _, inline := (*match.Fields)[key]; inline {
+				continue
+			}
+		}
+		actual, ok := data[key]
+		if !ok {
+			continue // Missing, which missingKeys reports.
+		}
+		matched, err := matcher.Match(actual)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			invalid = append(invalid, key)
+		}
+	}
+	return
+}
+
+// extraKeys returns the application's keys in data that an
+// ExactFields expectation doesn't mention, sorted.
+func (match *logsMatch) extraKeys(data logrus.Fields) (extra []string) {
+	if !match.exact {
+		return
+	}
+	for key := range appFields(data) {
+		if _, ok := (*match.Fields)[key]; !ok && !match.Ignore.has(key) {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	return
+}
+
+// withFields introduces the expected fields in failure messages.
+func (match *logsMatch) withFields() string {
+	if match.exact {
+		return "with exactly"
+	}
+	return "with"
+}
+
+// lackedKeys returns the keys an ExactFields expectation wants (other
+// than Absent ones) that data doesn't have, sorted.
+func (match *logsMatch) lackedKeys(data logrus.Fields) (lacked []string) {
+	if !match.exact {
+		return
+	}
+	for key, value := range *match.Fields {
+		if _, absent := value.(absentField); absent || match.Ignore.has(key) {
+			continue
+		}
+		if _, ok := data[key]; !ok {
+			lacked = append(lacked, key)
+		}
+	}
+	sort.Strings(lacked)
+	return
+}
+
+// fieldsMatch checks an entry's data against the field-specs
+// attached to this match.
+func (match *logsMatch) fieldsMatch(data logrus.Fields) (bool, error) {
+	logMut.Lock()
+	defer logMut.Unlock()
+	if len(match.missingKeys(data)) > 0 {
+		return false, nil
+	}
+	if invalid, err := match.invalidKeys(data); err != nil || len(invalid) > 0 {
+		return false, err
+	}
+	if match.Fields == nil {
+		return true, nil
+	}
+	if len(match.extraKeys(data)) > 0 {
+		return false, nil
+	}
+	for key, value := range *match.Fields {
+		if match.Ignore.has(key) {
+			continue
+		}
+		actual, ok := data[key]
+		if _, absent := value.(absentField); absent {
+			if ok {
+				return false, nil // There and shouldn't be.
+			}
+			continue
+		}
+		if !ok {
+			return false, nil // Not there, no match.
+		}
+		matched, err := valueMatch(value, actual)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// valueMatch compares a field value with what was expected of it: a
+// matcher, nested fields or a plain value.
+func valueMatch(expected, actual interface{}) (bool, error) {
+	switch expected := expected.(type) {
+	case *matchers.MatchErrorMatcher:
+		if s, ok := actual.(string); ok {
+			return errorStringMatch(expected, s)
+		}
+		return expected.Match(actual)
+	case types.GomegaMatcher:
+		return expected.Match(actual)
+	case logrus.Fields:
+		return nestedMatch(expected, actual)
+	case map[string]interface{}:
+		return nestedMatch(logrus.Fields(expected), actual)
+	}
+	expected, actual = derefPair(expected, actual)
+	return (&matchers.EqualMatcher{Expected: expected}).Match(actual)
+}
+
+// errorStringMatch applies a MatchError() matcher to the message of an
+// error that was stored as a string.
+func errorStringMatch(m *matchers.MatchErrorMatcher, message string) (bool, error) {
+	switch expected := m.Expected.(type) {
+	case error:
+		return message == expected.Error(), nil
+	case string:
+		return message == expected, nil
+	case types.GomegaMatcher:
+		return expected.Match(message)
+	}
+	return m.Match(message)
+}
+
+// nestedMatch matches a map field value against nested field
+// expectations, the same way an entry's fields are matched. Keys that
+// aren't mentioned are ignored.
+func nestedMatch(expected logrus.Fields, actual interface{}) (bool, error) {
+	_, actual = derefPair(expected, actual)
+	m := reflect.ValueOf(actual)
+	if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String {
+		return false, nil
+	}
+	for key, value := range expected {
+		v := m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key()))
+		if _, absent := value.(absentField); absent {
+			if v.IsValid() {
+				return false, nil
+			}
+			continue
+		}
+		if !v.IsValid() {
+			return false, nil
+		}
+		matched, err := valueMatch(value, v.Interface())
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// derefPair lets a pointer field value be compared against a plain
+// expected value (or the reverse) by following the pointer. Nil
+// pointers are left alone so they never equal a value.
+func derefPair(expected, actual interface{}) (interface{}, interface{}) {
+	e, a := reflect.ValueOf(expected), reflect.ValueOf(actual)
+	switch {
+	case a.Kind() == reflect.Ptr && e.Kind() != reflect.Ptr && !a.IsNil():
+		actual = a.Elem().Interface()
+	case e.Kind() == reflect.Ptr && a.Kind() != reflect.Ptr && !e.IsNil():
+		expected = e.Elem().Interface()
+	}
+	return expected, actual
+}
+
+// nearMissMessage explains why an entry whose message matched was
+// still rejected by the level or field-specs.
+func (match *logsMatch) nearMissMessage() (message string) {
+	if match.nearMiss == nil {
+		return
+	}
+	message = fmt.Sprintf("closest entry %q logged at %s\n", match.nearMiss.Message, loggedAt(match.nearMiss))
+	if match.Level != nil && match.nearMiss.Level != *match.Level {
+		message += fmt.Sprintf("    is at level %s, expected %s\n", match.nearMiss.Level, *match.Level)
+	}
+	if missing := match.missingKeys(match.nearMiss.Data); len(missing) > 0 {
+		message += fmt.Sprintf("    is missing keys %v\n", missing)
+	}
+	if invalid, _ := match.invalidKeys(match.nearMiss.Data); len(invalid) > 0 {
+		message += fmt.Sprintf("    has keys %v that fail their registered matchers\n", invalid)
+	}
+	if !match.window.contains(match.nearMiss.Time) {
+		message += fmt.Sprintf("    was logged at %s, more than %v ago\n", match.nearMiss.Time.Format(time.RFC3339Nano), match.window.d)
+	}
+	if present := match.presentKeys(match.nearMiss.Data); len(present) > 0 {
+		message += fmt.Sprintf("    has keys %v that should be absent\n", present)
+	}
+	if extra := match.extraKeys(match.nearMiss.Data); len(extra) > 0 {
+		message += fmt.Sprintf("    has extra keys %v\n", extra)
+	}
+	if lacked := match.lackedKeys(match.nearMiss.Data); len(lacked) > 0 {
+		message += fmt.Sprintf("    lacks keys %v\n", lacked)
+	}
+	return
+}
+
+// matches checks an entry's message, level and fields. An entry whose
+// message matches but whose level or fields don't is kept as a near
+// miss.
+func (match *logsMatch) matches(entry *markedEntry) (bool, error) {
+	if !strings.HasPrefix(entry.Message, match.prefix) {
+		return false, nil
+	}
+	doesMatch, err := match.Expected.Match(strings.TrimPrefix(entry.Message, match.prefix))
+	if err != nil || !doesMatch {
+		return false, err
+	}
+	if match.Level != nil && entry.Level != *match.Level {
+		match.nearMiss = entry
+		return false, nil
+	}
+	if !match.window.contains(entry.Time) {
+		match.nearMiss = entry
+		return false, nil
+	}
+	fieldsMatch, err := match.fieldsMatch(entry.Data)
+	if err != nil {
+		return false, err
+	}
+	if !fieldsMatch { // Message matched but the fields didn't.
+		match.nearMiss = entry
+		return false, nil
+	}
+	return true, nil
+}
+
+// setLevel attaches a level to the preceding matchers that don't have
+// one yet.
+func (m *logsMatcher) setLevel(level logrus.Level) {
+	for i := len(m.Matchers) - 1; i >= 0; i-- {
+		if m.Matchers[i].Level != nil {
+			break
+		}
+		m.Matchers[i].Level = &level
+	}
+}
+
+// full reports whether a ranged expectation has matched as many
+// entries as it may.
+func (match *logsMatch) full() bool {
+	return match.ranged && match.max > 0 && match.count >= match.max
+}
+
+// take records an entry as matching this expectation.
+func (match *logsMatch) take(entry *markedEntry) {
+	match.count++
+	if !match.ranged || match.count == 1 {
+		match.Entry = entry
+	}
+	match.matched = !match.ranged || match.count >= match.min
+	entry.matched = true
+}
+
+// describe renders the expected message for failure messages.
+func (match *logsMatch) describe() string {
+	if eq, ok := match.Expected.(*matchers.EqualMatcher); ok {
+		return describe(eq.Expected)
+	}
+	return describe(match.Expected)
+}
+
+// countMessage describes how many entries a ranged expectation
+// matched.
+func (match *logsMatch) countMessage() string {
+	if !match.ranged {
+		return ""
+	}
+	if match.max > 0 {
+		return fmt.Sprintf("    matched %d times, expected %d to %d\n", match.count, match.min, match.max)
+	}
+	return fmt.Sprintf("    matched %d times, expected at least %d\n", match.count, match.min)
+}
+
 func (m *logsMatcher) numMatchersLeft() (count int) {
 	for _, match := range m.Matchers {
 		if !match.matched {
@@ -136,144 +965,329 @@ func (m *logsMatcher) numMatchersLeft() (count int) {
 }
 
 func (m *logsMatcher) Match(actual interface{}) (success bool, err error) {
+	if m.err != nil {
+		return false, m.err
+	}
 	// Reset match indicators
+	m.NonMatching = nil
+	m.outOfOrder, m.skipped = nil, nil
+	m.overflow, m.overflowEntry = nil, nil
 	for _, match := range m.Matchers {
-		match.matched = false
+		match.matched = match.ranged && match.min <= 0
+		match.nearMiss = nil
+		match.valueFor = actual.(*LogCap).valueFor
+		match.count = 0
+		if match.ranged {
+			match.Entry = nil
+		}
 	}
 	hook := actual.(*LogCap)
+	m.hook = hook
+	if m.span != "" {
+		s, ok := hook.findSpan(m.span)
+		if !ok {
+			return false, fmt.Errorf("no span named %q, call Begin(%q) first", m.span, m.span)
+		}
+		m.accept = s.contains
+	}
 	hook.cacheMut.Lock()
 	defer hook.cacheMut.Unlock()
 	var entry *markedEntry
 
+	var deadline <-chan time.Time
+	var done <-chan struct{}
+	if m.ctx != nil {
+		done = m.ctx.Done()
+	}
+	if m.noWait || m.timeout <= 0 {
+		// With no time to wait, what's already been logged must
+		// all be looked at before the deadline can win the select.
+		hook.drain()
+	}
+	if !m.noWait {
+		// The timeout covers the whole match, so a steady stream
+		// of other logs can't keep it waiting forever.
+		deadline = time.After(m.timeout)
+	}
 	cacheTop := 0
 MainLoop:
 	// Loop until all matched or timeout.
 	for m.numMatchersLeft() > 0 {
 		if cacheTop < len(hook.cache) { // Look at old logs first.
 			entry = hook.cache[cacheTop]
+		} else if m.noWait {
+			return false, nil
 		} else {
 			select {
-			case e := <-hook.entries:
-				entry = &markedEntry{e, false}
-			case <-time.After(m.timeout):
+			case entry = <-hook.entries:
+			case <-deadline:
+				return false, nil
+			case <-done:
 				return false, nil
 			}
-			hook.cache = append(hook.cache, entry)
+			hook.store(entry)
 			// fmt.Printf("I see %s [%d] with %+v [%d]\n", entry.Message, len(hook.entries), entry.Data, m.numMatchersLeft())
 		}
 		cacheTop++
 		if entry.matched { // We've already matched this one.
 			continue MainLoop
 		}
+		if m.accept != nil && !m.accept(entry) {
+			continue MainLoop
+		}
+		var next *logsMatch // First unmatched expectation
+		var full *logsMatch // Ranged expectation this entry would overflow
 	MatchLoop:
 		// Find a matcher for this entry
 		for _, matchItem := range m.Matchers {
-			if matchItem.matched { // Already matched it.
+			if matchItem.matched && !matchItem.ranged { // Already matched it.
 				continue MatchLoop
 			}
-			doesMatch, err := matchItem.Expected.Match(entry.Message)
+			if next == nil && !matchItem.matched {
+				next = matchItem
+			}
+			doesMatch, err := matchItem.matches(entry)
 			if err != nil {
 				return false, err
 			}
 			if !doesMatch { // Nope, try the next one.
 				continue MatchLoop
 			}
-			if matchItem.Fields != nil {
-				logMut.Lock()
-				data := entry.Data
-				for key, value := range *matchItem.Fields {
-					var matcher types.GomegaMatcher
-					switch value := value.(type) {
-					case types.GomegaMatcher:
-						matcher = value
-					default:
-						matcher = &matchers.EqualMatcher{Expected: value}
-					}
-					if _, ok := data[key]; !ok {
-						logMut.Unlock()
-						continue MatchLoop // Not there, no match.
-					}
-					matched, err := matcher.Match(data[key])
-					if err != nil {
-						logMut.Unlock()
-						return false, err
-					}
-					if !matched {
-						logMut.Unlock()
-						continue MatchLoop
-					}
+			if matchItem.full() {
+				if full == nil {
+					full = matchItem
 				}
-				logMut.Unlock()
+				continue MatchLoop
+			}
+			if m.ordered && !matchItem.matched && matchItem != next {
+				m.outOfOrder = entry
+				m.skipped = next
+				return false, nil
 			}
-			matchItem.matched = true
-			entry.matched = true
-			matchItem.Entry = entry
+			matchItem.take(entry)
 			continue MainLoop
 		}
-		m.NonMatching = entry
+		if full != nil {
+			m.overflow, m.overflowEntry = full, entry
+			return false, nil
+		}
+		// Nothing wants this entry. Remember it for the failure
+		// message and keep looking, since batched logs can arrive
+		// a little out of order.
+		m.NonMatching = append(m.NonMatching, entry)
+		if m.strict {
+			return false, nil
+		}
+	}
+	ok, err := m.fillRanges(cacheTop)
+	if ok {
+		m.NonMatching = nil
+	}
+	return ok, err
+}
+
+// fillRanges gives ranged expectations the entries logged so far that
+// the main loop didn't get to, so that too many matching entries are
+// noticed. The caller must hold cacheMut.
+func (m *logsMatcher) fillRanges(cacheTop int) (bool, error) {
+	var ranged []*logsMatch
+	for _, match := range m.Matchers {
+		if match.ranged {
+			ranged = append(ranged, match)
+		}
+	}
+	if len(ranged) == 0 {
+		return true, nil
+	}
+	m.hook.drain()
+EntryLoop:
+	for _, entry := range m.hook.cache[cacheTop:] {
+		if entry.matched || (m.accept != nil && !m.accept(entry)) {
+			continue
+		}
+		for _, match := range ranged {
+			doesMatch, err := match.matches(entry)
+			if err != nil {
+				return false, err
+			}
+			if !doesMatch {
+				continue
+			}
+			if !match.full() {
+				match.take(entry)
+				continue EntryLoop
+			}
+			m.overflow, m.overflowEntry = match, entry
+			return false, nil
+		}
 	}
 	return true, nil
 }
 
 func (m *logsMatcher) baseMessage(matched bool) (message string) {
 	for _, matchEntry := range m.Matchers {
-		if m.NonMatching != nil {
+		if len(m.NonMatching) > 0 {
 			if matchEntry.matched { // Don't report on things I know about
 				continue
 			}
-			moMessage := m.NonMatching.Message
-			moMessage += fmt.Sprintf("\n    logged at %s:%d\n", m.NonMatching.Data["file"], m.NonMatching.Data["line"])
+			first := m.NonMatching[0]
+			moMessage := first.Message
+			moMessage += fmt.Sprintf("\n    logged at %s\n", loggedAt(first))
+			moMessage += first.stackMessage()
 
-			if len(m.NonMatching.Data) > 2 {
-				data := logrus.Fields{}
-				for k, v := range m.NonMatching.Data {
-					if k == "file" || k == "line" {
-						continue
-					}
-					data[k] = v
-				}
+			if data := m.hook.userFields(first.Data); len(data) > 0 {
 				moMessage += fmt.Sprintf("    with %#v", data)
 			}
 			message += matchEntry.Expected.FailureMessage(moMessage) + "\n"
 			if matchEntry.Fields != nil {
-				message += fmt.Sprintf("        with %#v\n", matchEntry.Fields)
+				message += fmt.Sprintf("        %s %#v\n", matchEntry.withFields(), m.hook.redacted(*matchEntry.Fields))
+			}
+			if matchEntry.Keys != nil {
+				message += fmt.Sprintf("        with keys %v\n", []string(matchEntry.Keys))
 			}
-			return
+			if matchEntry.Level != nil {
+				message += fmt.Sprintf("        at level %s\n", *matchEntry.Level)
+			}
+			message += matchEntry.nearMissMessage()
+			message += matchEntry.countMessage()
+			return message + m.nonMatchingMessage(m.NonMatching[1:], "Other nonmatching logs:\n")
 		}
 		if matchEntry.matched == matched {
-			if matched {
+			if matched && matchEntry.Entry == nil { // A range that allows no matches
+				message += fmt.Sprintf("Expected logs matching %s not to match\n", matchEntry.describe())
+				message += matchEntry.countMessage()
+			} else if matched {
 				message += matchEntry.Expected.NegatedFailureMessage(matchEntry.Entry.Message) + "\n"
-				message += fmt.Sprintf("logged at %s:%d\n", matchEntry.Entry.Data["file"], matchEntry.Entry.Data["line"])
+				message += fmt.Sprintf("logged at %s\n", loggedAt(matchEntry.Entry))
 			} else {
 				message += matchEntry.Expected.FailureMessage(nil) + "\n"
 			}
 			if matchEntry.Fields != nil {
-				message += fmt.Sprintf("with %#v\n", matchEntry.Fields)
+				message += fmt.Sprintf("%s %#v\n", matchEntry.withFields(), m.hook.redacted(*matchEntry.Fields))
+			}
+			if matchEntry.Keys != nil {
+				message += fmt.Sprintf("with keys %v\n", []string(matchEntry.Keys))
+			}
+			if matchEntry.Level != nil {
+				message += fmt.Sprintf("at level %s\n", *matchEntry.Level)
+			}
+			if !matched {
+				message += matchEntry.nearMissMessage()
+				message += matchEntry.countMessage()
 			}
 		}
 	}
-	if m.NonMatching != nil {
-		message += "Nonmatching log:\n"
-		message += "  " + m.NonMatching.Message + "\n"
-		message += fmt.Sprintf("    logged at %s:%d\n", m.NonMatching.Data["file"], m.NonMatching.Data["line"])
-		if len(m.NonMatching.Data) > 2 {
-			data := logrus.Fields{}
-			for k, v := range m.NonMatching.Data {
-				if k == "file" || k == "line" {
-					continue
-				}
-				data[k] = v
-			}
+	return message + m.nonMatchingMessage(m.NonMatching, "Nonmatching logs:\n")
+}
+
+// nonMatchingMessage lists entries that matched no expectation under
+// a heading, or returns "" if there are none.
+func (m *logsMatcher) nonMatchingMessage(entries []*markedEntry, heading string) (message string) {
+	if len(entries) == 0 {
+		return
+	}
+	message = heading
+	for _, entry := range entries {
+		message += "  " + entry.Message + "\n"
+		message += fmt.Sprintf("    logged at %s\n", loggedAt(entry))
+		if data := m.hook.userFields(entry.Data); len(data) > 0 {
 			message += fmt.Sprintf("    with %#v\n", data)
 		}
+		message += entry.stackMessage()
+	}
+	return
+}
+
+// stackMessage lists the call stack recorded for an entry, if any.
+func (entry *markedEntry) stackMessage() (message string) {
+	if len(entry.stack) == 0 {
+		return
+	}
+	message = "    stack:\n"
+	for _, frame := range entry.stack {
+		message += "      " + frame + "\n"
+	}
+	return
+}
+
+func (m *logsMatcher) MatchedEntries() []*logrus.Entry {
+	entries := make([]*logrus.Entry, len(m.Matchers))
+	for i, match := range m.Matchers {
+		if match.matched && match.Entry != nil {
+			entries[i] = match.Entry.Entry
+		}
+	}
+	return entries
+}
+
+func (m *logsMatcher) Summary() (summary string) {
+	for _, match := range m.Matchers {
+		switch {
+		case match.ranged && match.Entry == nil:
+			summary += fmt.Sprintf("%s matched 0 times\n", match.describe())
+		case match.ranged:
+			summary += fmt.Sprintf("%s matched %d times, first %q\n    logged at %s\n",
+				match.describe(), match.count, match.Entry.Message, loggedAt(match.Entry))
+		case !match.matched:
+			summary += fmt.Sprintf("%s didn't match\n", match.describe())
+		default:
+			summary += fmt.Sprintf("%s matched %q\n    logged at %s\n", match.describe(), match.Entry.Message, loggedAt(match.Entry))
+		}
+	}
+	return
+}
+
+func (m *logsMatcher) Timeout() time.Duration {
+	return m.timeout
+}
+
+func (m *logsMatcher) Unmatched() (expectations []string, entries []*logrus.Entry) {
+	for _, match := range m.Matchers {
+		if !match.matched {
+			expectations = append(expectations, match.describe())
+		}
+	}
+	if m.hook == nil {
+		return
+	}
+	m.hook.cacheMut.Lock()
+	defer m.hook.cacheMut.Unlock()
+	for _, entry := range m.hook.cache {
+		if !entry.matched && (m.accept == nil || m.accept(entry)) {
+			entries = append(entries, entry.Entry)
+		}
 	}
 	return
 }
 
 func (m *logsMatcher) FailureMessage(actual interface{}) (message string) {
+	if m.outOfOrder != nil {
+		return m.orderMessage()
+	}
+	if m.overflow != nil {
+		return m.overflowMessage()
+	}
 	return m.baseMessage(false)
 }
 
+// overflowMessage explains a ranged expectation that matched too
+// many entries.
+func (m *logsMatcher) overflowMessage() string {
+	return fmt.Sprintf("Expected %d to %d logs matching %s. Instead, got more than %d; the next was logged at %s\n",
+		m.overflow.min, m.overflow.max, m.overflow.describe(), m.overflow.max, loggedAt(m.overflowEntry))
+}
+
+// orderMessage explains an entry that turned up out of order.
+func (m *logsMatcher) orderMessage() (message string) {
+	message = fmt.Sprintf("Expected logs in order. Instead, got %q out of sequence\n    logged at %s\n",
+		m.outOfOrder.Message, loggedAt(m.outOfOrder))
+	message += "before finding:\n" + m.skipped.Expected.FailureMessage(nil) + "\n"
+	if m.skipped.Fields != nil {
+		message += fmt.Sprintf("%s %#v\n", m.skipped.withFields(), m.hook.redacted(*m.skipped.Fields))
+	}
+	return
+}
+
 func (m *logsMatcher) NegatedFailureMessage(actual interface{}) (message string) {
 	return m.baseMessage(true)
 }
@@ -282,22 +1296,12 @@ func (m *noLogsMatcher) Match(actual interface{}) (success bool, err error) {
 	hook := actual.(*LogCap)
 	hook.cacheMut.Lock()
 	defer hook.cacheMut.Unlock()
-	l := len(hook.entries) + len(hook.cache)
-	var entry *markedEntry
-	cacheTop := 0
-	for i := 0; i < l; i++ {
-		if cacheTop < len(hook.cache) {
-			entry = hook.cache[cacheTop]
-		} else {
-			e := <-hook.entries
-			entry = &markedEntry{e, false}
-			hook.cache = append(hook.cache, entry)
-		}
-		cacheTop++
-		if m.level != nil && entry.Level != *m.level {
-			continue
-		}
-		if !entry.matched { // Count non-matched entries
+	// Count without consuming, so a later HaveLogs() sees the
+	// same entries whatever order the assertions run in.
+	hook.drain()
+	m.found = 0
+	for _, entry := range hook.cache {
+		if m.counts(entry) {
 			m.found++
 		}
 	}
@@ -306,26 +1310,18 @@ func (m *noLogsMatcher) Match(actual interface{}) (success bool, err error) {
 
 func (m *noLogsMatcher) FailureMessage(actual interface{}) (message string) {
 	hook := actual.(*LogCap)
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
 	message = fmt.Sprintf("Expected no logs. Instead, got %d:", m.found)
 	for _, entry := range hook.cache {
-		if m.level != nil && entry.Level != *m.level {
-			continue
-		}
-		if entry.matched {
+		if !m.counts(entry) {
 			continue
 		}
 		extra := ""
-		if len(entry.Data) > 2 {
-			data := logrus.Fields{}
-			for k, v := range entry.Data {
-				if k == "file" || k == "line" {
-					continue
-				}
-				data[k] = v
-			}
+		if data := hook.userFields(entry.Data); len(data) > 0 {
 			extra = fmt.Sprintf(" (%v)", data)
 		}
-		message = message + fmt.Sprintf("\n  %s%s\n  logged at %s:%d", entry.Message, extra, entry.Data["file"], entry.Data["line"])
+		message = message + fmt.Sprintf("\n  %s: %s%s\n  logged at %s", entry.Level, entry.Message, extra, loggedAt(entry))
 	}
 	return
 }
@@ -336,13 +1332,224 @@ func (m *noLogsMatcher) NegatedFailureMessage(actual interface{}) (message strin
 	defer hook.cacheMut.Unlock()
 	message = fmt.Sprintf("Did not expect 0 logs\n")
 	for _, entry := range hook.cache {
-		if m.level != nil && entry.Level != *m.level {
-			continue
-		}
-		if entry.matched {
+		if !m.counts(entry) {
 			continue
 		}
-		message = message + fmt.Sprintf("\n%s\n  logged at %s:%d", entry.Message, entry.Data["file"], entry.Data["line"])
+		message = message + fmt.Sprintf("\n%s\n  logged at %s", entry.Message, loggedAt(entry))
 	}
 	return
 }
+
+// ExpectSequenceThenAny checks that the first logs not yet matched by
+// an earlier assertion match the given sequence of strings/matchers,
+// in order and with nothing in between. Anything logged after the
+// sequence is allowed and is drained so that it won't trip a later
+// HaveNoLogs(). This suits protocols with a fixed handshake followed
+// by variable traffic:
+//
+//   err := logHook.ExpectSequenceThenAny([]interface{}{"hello", "auth ok", MatchRegexp(`session \d+`)})
+//
+// It waits up to the default timeout (see SetDefaultTimeout) for the
+// whole sequence to be logged and returns an error describing the
+// first entry out of place.
+func (hook *LogCap) ExpectSequenceThenAny(sequence []interface{}) error {
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
+
+	deadline := time.After(defaultTimeout())
+	cursor := 0
+	var seen []*markedEntry
+	for i, expected := range sequence {
+		matcher := matcherOrEqual(expected).Expected
+		for ; ; cursor++ {
+			if !hook.fill(cursor+1, deadline) {
+				return fmt.Errorf("only %d of %d sequence logs were captured", i, len(sequence))
+			}
+			if !hook.cache[cursor].matched {
+				break
+			}
+		}
+		entry := hook.cache[cursor]
+		cursor++
+		ok, err := matcher.Match(entry.Message)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("log %d of sequence out of place:\n%s\nlogged at %s",
+				i, matcher.FailureMessage(entry.Message), loggedAt(entry))
+		}
+		seen = append(seen, entry)
+	}
+	for _, entry := range seen {
+		entry.matched = true
+	}
+	hook.drain()
+	for _, entry := range hook.cache {
+		entry.matched = true
+	}
+	return nil
+}
+
+// AssertAndClear matches logs just like HaveLogs(args...) and, if they
+// all match, throws away everything captured so far so that the next
+// assertion starts fresh. It returns an error holding the failure
+// message if they don't match, leaving the capture alone. This suits
+// table-driven tests that share a hook between cases:
+//
+//   for _, c := range cases {
+//   	c.run()
+//   	Ω(logHook.AssertAndClear(c.logs...)).Should(Succeed())
+//   }
+func (hook *LogCap) AssertAndClear(args ...interface{}) error {
+	m := HaveLogs(args...)
+	ok, err := m.Match(hook)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(m.FailureMessage(hook))
+	}
+	hook.Reset()
+	return nil
+}
+
+type countLogsMatcher struct {
+	message  interface{}
+	expected types.GomegaMatcher
+	count    types.GomegaMatcher
+	timeout  time.Duration
+	found    int
+}
+
+// CountLogs counts the captured entries whose message matches the
+// given string or matcher and checks the count with a Gomega matcher.
+// This asserts quantities without listing every log:
+//
+//   Ω(logHook).Should(CountLogs(ContainSubstring("retry"), BeNumerically(">=", 10)))
+//
+// Matched and unmatched entries are both counted, and none are marked
+// as matched. If the count doesn't satisfy the matcher yet, CountLogs
+// keeps counting new entries as they arrive until it does or the
+// timeout (the default timeout, see SetDefaultTimeout, or an optional
+// time.Duration argument) runs out.
+func CountLogs(message interface{}, count types.GomegaMatcher, args ...interface{}) types.GomegaMatcher {
+	m := &countLogsMatcher{
+		message:  message,
+		expected: matcherOrEqual(message).Expected,
+		count:    count,
+		timeout:  defaultTimeout(),
+	}
+	for _, arg := range args {
+		if d, ok := arg.(time.Duration); ok {
+			m.timeout = d
+		}
+	}
+	return m
+}
+
+func (m *countLogsMatcher) Match(actual interface{}) (success bool, err error) {
+	hook := actual.(*LogCap)
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
+	hook.drain()
+	m.found = 0
+	deadline := time.After(m.timeout)
+	for counted := 0; ; {
+		for ; counted < len(hook.cache); counted++ {
+			ok, err := m.expected.Match(hook.cache[counted].Message)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				m.found++
+			}
+		}
+		if ok, err := m.count.Match(m.found); err != nil || ok {
+			return ok, err
+		}
+		select {
+		case entry := <-hook.entries:
+			hook.store(entry)
+		case <-deadline:
+			return false, nil
+		}
+	}
+}
+
+func (m *countLogsMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Counting logs matching %s:\n%s", describe(m.message), m.count.FailureMessage(m.found))
+}
+
+func (m *countLogsMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Counting logs matching %s:\n%s", describe(m.message), m.count.NegatedFailureMessage(m.found))
+}
+
+type neverLogsMatcher struct {
+	message   interface{}
+	expected  types.GomegaMatcher
+	timeout   time.Duration
+	offending *markedEntry
+}
+
+// NeverLogs checks that no captured entry's message matches the given
+// string or matcher, while other logs come and go as they please:
+//
+//   Ω(logHook).Should(NeverLogs(ContainSubstring("secret")))
+//
+// Unlike HaveNoLogs(), it doesn't care whether the other logs were
+// matched. It watches new entries until the timeout (the default
+// timeout, or an optional time.Duration argument) runs out, so a
+// passing assertion takes that long, but it fails as soon as a
+// matching entry turns up.
+// Matched entries count too, and nothing is marked as matched.
+func NeverLogs(message interface{}, args ...interface{}) types.GomegaMatcher {
+	m := &neverLogsMatcher{
+		message:  message,
+		expected: matcherOrEqual(message).Expected,
+		timeout:  defaultTimeout(),
+	}
+	for _, arg := range args {
+		if d, ok := arg.(time.Duration); ok {
+			m.timeout = d
+		}
+	}
+	return m
+}
+
+func (m *neverLogsMatcher) Match(actual interface{}) (success bool, err error) {
+	hook := actual.(*LogCap)
+	hook.cacheMut.Lock()
+	defer hook.cacheMut.Unlock()
+	hook.drain()
+	m.offending = nil
+	deadline := time.After(m.timeout)
+	for checked := 0; ; {
+		for ; checked < len(hook.cache); checked++ {
+			entry := hook.cache[checked]
+			ok, err := m.expected.Match(entry.Message)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				m.offending = entry
+				return false, nil
+			}
+		}
+		select {
+		case entry := <-hook.entries:
+			hook.store(entry)
+		case <-deadline:
+			return true, nil
+		}
+	}
+}
+
+func (m *neverLogsMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected no logs matching %s. Instead, got %q\n    logged at %s",
+		describe(m.message), m.offending.Message, loggedAt(m.offending))
+}
+
+func (m *neverLogsMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected a log matching %s, found none", describe(m.message))
+}
@@ -0,0 +1,119 @@
+package logcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/onsi/gomega/format"
+	"github.com/onsi/gomega/types"
+	"github.com/sirupsen/logrus"
+)
+
+// The matchers in this file are meant for use in place of a message
+// string in HaveLogs(). Like any matcher given there, they're applied
+// to the entry's Message only; a logrus.Fields{} argument after them
+// still checks the entry's fields.
+
+type jsonMessageMatcher struct {
+	expected logrus.Fields
+	parseErr error
+}
+
+// JSONMessage matches a message holding a JSON object whose keys match
+// the given fields. Only the keys mentioned are checked, and values
+// work the same way as in a logrus.Fields{} argument to HaveLogs():
+// plain values, matchers, nested fields and Absent are all allowed.
+//
+//   logrus.WithField("user", "ann").Info(`{"event":"login","attempt":2}`)
+//   Ω(logHook).Should(HaveLogs(
+//   	logcap.JSONMessage(logrus.Fields{"event": "login", "attempt": 2}),
+//   	logrus.Fields{"user": "ann"}))
+//
+// Plain expected values are put through encoding/json before they're
+// compared, so 2 matches the parsed number 2. A message that isn't a
+// JSON object doesn't match.
+func JSONMessage(expected logrus.Fields) types.GomegaMatcher {
+	return &jsonMessageMatcher{expected: expected}
+}
+
+func (m *jsonMessageMatcher) Match(actual interface{}) (success bool, err error) {
+	message, ok := actual.(string)
+	if !ok {
+		return false, nil
+	}
+	var parsed map[string]interface{}
+	if m.parseErr = json.Unmarshal([]byte(message), &parsed); m.parseErr != nil {
+		return false, nil
+	}
+	expected, err := jsonExpected(m.expected)
+	if err != nil {
+		return false, err
+	}
+	return nestedMatch(expected.(logrus.Fields), parsed)
+}
+
+// jsonExpected puts the plain values among expected fields through
+// encoding/json, leaving matchers and Absent alone.
+func jsonExpected(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case types.GomegaMatcher, absentField:
+		return v, nil
+	case logrus.Fields:
+		return jsonExpectedFields(v)
+	case map[string]interface{}:
+		return jsonExpectedFields(logrus.Fields(v))
+	}
+	return jsonNormalize(v)
+}
+
+func jsonExpectedFields(fields logrus.Fields) (logrus.Fields, error) {
+	normal := logrus.Fields{}
+	for k, v := range fields {
+		n, err := jsonExpected(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %q can't be encoded: %v", k, err)
+		}
+		normal[k] = n
+	}
+	return normal, nil
+}
+
+func (m *jsonMessageMatcher) FailureMessage(actual interface{}) (message string) {
+	if m.parseErr != nil {
+		return format.Message(actual, fmt.Sprintf("to be a JSON object (%v) matching", m.parseErr), m.expected)
+	}
+	return format.Message(actual, "to be a JSON object matching", m.expected)
+}
+
+func (m *jsonMessageMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return format.Message(actual, "not to be a JSON object matching", m.expected)
+}
+
+type containLogMatcher struct {
+	substring string
+}
+
+// ContainLog matches a message containing substring, ignoring case,
+// without the regexp escaping and (?i) flag MatchRegexp() would need:
+//
+//   Ω(logHook).Should(HaveLogs(logcap.ContainLog("connection reset")))
+func ContainLog(substring string) types.GomegaMatcher {
+	return &containLogMatcher{substring: substring}
+}
+
+func (m *containLogMatcher) Match(actual interface{}) (success bool, err error) {
+	message, ok := actual.(string)
+	if !ok {
+		return false, nil
+	}
+	return strings.Contains(strings.ToLower(message), strings.ToLower(m.substring)), nil
+}
+
+func (m *containLogMatcher) FailureMessage(actual interface{}) (message string) {
+	return format.Message(actual, "to contain, ignoring case", m.substring)
+}
+
+func (m *containLogMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return format.Message(actual, "not to contain, ignoring case", m.substring)
+}
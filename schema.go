@@ -0,0 +1,216 @@
+package logcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/onsi/gomega/types"
+)
+
+type schemaContractMatcher struct {
+	schemaJSON string
+	count      int
+	failures   []string
+}
+
+// HaveLogsMatchingSchema checks the fields of every captured entry
+// against a JSON schema, enforcing a structured-logging contract:
+//
+//   Ω(logHook).Should(HaveLogsMatchingSchema(`{
+//     "type": "object",
+//     "required": ["request_id"],
+//     "properties": {
+//       "request_id": {"type": "string", "pattern": "^req-"},
+//       "status": {"type": "integer", "minimum": 100, "maximum": 599}
+//     }
+//   }`))
+//
+// The fields are put through encoding/json first, as a JSON formatter
+// would, and the file and line added by logcap are left out. Only the
+// common validation keywords are supported: type, enum, properties,
+// required, additionalProperties, items, pattern, minLength,
+// maxLength, minimum and maximum. Others are ignored. It fails if
+// nothing has been captured, and Match returns an error if the schema
+// isn't valid JSON.
+func HaveLogsMatchingSchema(schemaJSON string) types.GomegaMatcher {
+	return &schemaContractMatcher{schemaJSON: schemaJSON}
+}
+
+func (m *schemaContractMatcher) Match(actual interface{}) (success bool, err error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(m.schemaJSON), &schema); err != nil {
+		return false, fmt.Errorf("bad schema: %v", err)
+	}
+	m.count = 0
+	m.failures = nil
+	hook := actual.(*LogCap)
+	for _, entry := range hook.snapshot() {
+		m.count++
+		fields := map[string]interface{}{}
+		for k, v := range appFields(entry.Data) {
+			normal, err := jsonNormalize(v)
+			if err != nil {
+				m.failures = append(m.failures, fmt.Sprintf("%q logged at %s: field %q can't be encoded: %v", entry.Message, loggedAt(entry), k, err))
+				continue
+			}
+			fields[k] = normal
+		}
+		if violations := validateSchema(schema, fields, "fields", hook.redact, false); len(violations) > 0 {
+			m.failures = append(m.failures, fmt.Sprintf("%q logged at %s: %s", entry.Message, loggedAt(entry), strings.Join(violations, ", ")))
+		}
+	}
+	return m.count > 0 && len(m.failures) == 0, nil
+}
+
+// validateSchema checks a JSON value against a schema, returning a
+// description of each violation. The path names the value in those
+// descriptions. Values under the redact keys of an object, and every
+// value when secret is set, are shown as "***".
+func validateSchema(schema map[string]interface{}, value interface{}, path string, redact map[string]bool, secret bool) (violations []string) {
+	shown := value
+	if secret {
+		shown = "***"
+	}
+	if t, ok := schema["type"]; ok && !schemaTypeOK(t, value) {
+		return []string{fmt.Sprintf("%s should be %v, is %s", path, t, jsonType(value))}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violations = append(violations, fmt.Sprintf("%s is %#v, not one of %v", path, shown, enum))
+		}
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, key := range required {
+				if _, ok := value[fmt.Sprint(key)]; !ok {
+					violations = append(violations, fmt.Sprintf("%s is missing %q", path, key))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		var keys []string
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sub := path + "." + key
+			hidden := secret || redact[key]
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				violations = append(violations, validateSchema(property, value[key], sub, nil, hidden)...)
+				continue
+			}
+			if _, ok := properties[key]; ok {
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					violations = append(violations, fmt.Sprintf("%s isn't allowed", sub))
+				}
+			case map[string]interface{}:
+				violations = append(violations, validateSchema(extra, value[key], sub, nil, hidden)...)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				violations = append(violations, validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i), nil, secret)...)
+			}
+		}
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				violations = append(violations, fmt.Sprintf("%s has a bad pattern: %v", path, err))
+			} else if !re.MatchString(value) {
+				violations = append(violations, fmt.Sprintf("%s is %q, doesn't match %q", path, shown, pattern))
+			}
+		}
+		if min, ok := schema["minLength"].(float64); ok && float64(len([]rune(value))) < min {
+			violations = append(violations, fmt.Sprintf("%s is shorter than %v", path, min))
+		}
+		if max, ok := schema["maxLength"].(float64); ok && float64(len([]rune(value))) > max {
+			violations = append(violations, fmt.Sprintf("%s is longer than %v", path, max))
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && value < min {
+			violations = append(violations, fmt.Sprintf("%s is %v, less than %v", path, shown, min))
+		}
+		if max, ok := schema["maximum"].(float64); ok && value > max {
+			violations = append(violations, fmt.Sprintf("%s is %v, more than %v", path, shown, max))
+		}
+	}
+	return
+}
+
+// schemaTypeOK reports whether a value has the schema type t, which
+// may be a single type name or a list of them.
+func schemaTypeOK(t interface{}, value interface{}) bool {
+	if names, ok := t.([]interface{}); ok {
+		for _, t := range names {
+			if schemaTypeOK(t, value) {
+				return true
+			}
+		}
+		return false
+	}
+	actual := jsonType(value)
+	switch t {
+	case actual:
+		return true
+	case "number":
+		return actual == "integer"
+	}
+	return false
+}
+
+// jsonType names the JSON schema type of a parsed JSON value.
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (m *schemaContractMatcher) FailureMessage(actual interface{}) (message string) {
+	if m.count == 0 {
+		return "Expected logs matching the schema, found none"
+	}
+	message = fmt.Sprintf("Expected every log's fields to match the schema. Instead, got %d failures:", len(m.failures))
+	for _, failure := range m.failures {
+		message += "\n  " + failure
+	}
+	return
+}
+
+func (m *schemaContractMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected some log's fields not to match the schema, but all %d did", m.count)
+}
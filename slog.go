@@ -0,0 +1,126 @@
+//go:build go1.21
+// +build go1.21
+
+package logcap
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SlogHandler returns an slog.Handler that captures records into the
+// hook, so the usual matchers work on code that logs with log/slog:
+//
+//   logHook := NewLogHook()
+//   logger := slog.New(logHook.SlogHandler())
+//   logger.Info("connected", "host", "db1")
+//   Ω(logHook).Should(HaveLogs("connected", logrus.Fields{"host": "db1"}))
+//
+// Levels are mapped to the nearest Logrus level (anything below
+// slog.LevelDebug is TraceLevel, anything above slog.LevelError is
+// ErrorLevel) and attributes become fields. Attributes in groups are
+// flattened with dotted keys, so slog.Group("req", "id", 7) becomes
+// the field "req.id". Int attributes are stored as int rather than
+// int64 so they compare equal to plain integer literals.
+//
+// The handler captures every level, whatever the level of the hook's
+// logger, and doesn't need Start(). Nothing is written anywhere.
+func (hook *LogCap) SlogHandler() slog.Handler {
+	return &slogHandler{hook: hook}
+}
+
+type slogHandler struct {
+	hook   *LogCap
+	fields logrus.Fields // From WithAttrs, already flattened
+	prefix string        // Dotted group path from WithGroup
+}
+
+func (h *slogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	data := logrus.Fields{}
+	for k, v := range h.fields {
+		data[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		flattenAttr(data, h.prefix, a)
+		return true
+	})
+	e := &logrus.Entry{
+		Logger:  h.hook.logger,
+		Time:    r.Time,
+		Level:   slogLevel(r.Level),
+		Message: r.Message,
+		Data:    data,
+	}
+	var (
+		file string
+		line int
+	)
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		file, line = frame.File, frame.Line
+	}
+	return h.hook.capture(e, file, line, nil)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := logrus.Fields{}
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for _, a := range attrs {
+		flattenAttr(fields, h.prefix, a)
+	}
+	return &slogHandler{hook: h.hook, fields: fields, prefix: h.prefix}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{hook: h.hook, fields: h.fields, prefix: h.prefix + name + "."}
+}
+
+// flattenAttr adds an attribute to data, turning groups into dotted
+// keys.
+func flattenAttr(data logrus.Fields, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" { // Groups without a key are inlined.
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			flattenAttr(data, prefix, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	var value interface{} = v.Any()
+	if i, ok := value.(int64); ok && int64(int(i)) == i {
+		value = int(i)
+	}
+	data[prefix+a.Key] = value
+}
+
+// slogLevel maps an slog level to the nearest Logrus level.
+func slogLevel(level slog.Level) logrus.Level {
+	switch {
+	case level < slog.LevelDebug:
+		return logrus.TraceLevel
+	case level < slog.LevelInfo:
+		return logrus.DebugLevel
+	case level < slog.LevelWarn:
+		return logrus.InfoLevel
+	case level < slog.LevelError:
+		return logrus.WarnLevel
+	}
+	return logrus.ErrorLevel
+}
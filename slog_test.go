@@ -0,0 +1,65 @@
+//go:build go1.21
+// +build go1.21
+
+package logcap
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SlogHandler", func() {
+	var (
+		logHook *LogCap
+		logger  *slog.Logger
+	)
+	BeforeEach(func() {
+		logHook = NewLogHook(logrus.New())
+		logger = slog.New(logHook.SlogHandler())
+	})
+	AfterEach(func() {
+		Ω(logHook).Should(HaveNoLogs())
+	})
+	It("captures slog records", func() {
+		logger.Info("connected", "host", "db1", "port", 5432)
+		Ω(logHook).Should(HaveLogs("connected", logrus.Fields{"host": "db1", "port": 5432}))
+	})
+	It("maps slog levels to Logrus levels", func() {
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error")
+		logger.Log(context.Background(), slog.LevelDebug-4, "trace")
+		Ω(logHook).Should(HaveLogs(
+			"debug", logrus.DebugLevel,
+			"info", logrus.InfoLevel,
+			"warn", logrus.WarnLevel,
+			"error", logrus.ErrorLevel,
+			"trace", logrus.TraceLevel,
+		))
+	})
+	It("flattens groups into dotted keys", func() {
+		logger.WithGroup("req").With("id", 7).Info("handled",
+			slog.Group("user", "name", "ann"),
+			slog.Group("", "inline", true))
+		Ω(logHook).Should(HaveLogs("handled", logrus.Fields{
+			"req.id":        7,
+			"req.user.name": "ann",
+			"req.inline":    true,
+		}))
+	})
+	It("records the time and call site", func() {
+		before := time.Now()
+		logger.Info("here")
+		records := logHook.EntriesWithSource()
+		Ω(records).Should(HaveLen(1))
+		Ω(records[0].Time).Should(BeTemporally("~", before, time.Second))
+		Ω(records[0].Source).Should(ContainSubstring("slog_test.go:"))
+		Ω(logHook).Should(HaveLogs("here"))
+	})
+})
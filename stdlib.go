@@ -0,0 +1,81 @@
+package logcap
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StdlibWriter returns an io.Writer that captures each line written to
+// it as an info-level entry, so the usual matchers work on code that
+// logs with the standard library's log package:
+//
+//   logHook := NewLogHook()
+//   log.SetOutput(logHook.StdlibWriter())
+//   log.SetFlags(0)
+//   log.Print("connected")
+//   Ω(logHook).Should(HaveLogs("connected"))
+//
+// Writes are split on newlines, so several lines in one write become
+// several entries and a line written in pieces becomes one entry once
+// its newline arrives. Whatever the log package puts in front of a
+// message (the date and time, unless its flags are cleared, and any
+// prefix) stays in the message. Entries have no fields, and their call
+// site is the first caller outside the log package.
+//
+// The writer doesn't need Start(). Nothing is written anywhere.
+func (hook *LogCap) StdlibWriter() io.Writer {
+	return &stdlibWriter{hook: hook}
+}
+
+type stdlibWriter struct {
+	hook    *LogCap
+	mut     sync.Mutex
+	partial []byte // Start of a line still waiting for its newline
+}
+
+func (w *stdlibWriter) Write(p []byte) (int, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		message := string(w.partial[:i])
+		w.partial = w.partial[i+1:]
+		file, line := stdlibCaller()
+		e := &logrus.Entry{
+			Logger:  w.hook.logger,
+			Time:    time.Now(),
+			Level:   logrus.InfoLevel,
+			Message: message,
+			Data:    logrus.Fields{},
+		}
+		if err := w.hook.capture(e, file, line, nil); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// stdlibCaller finds the first caller outside this writer and the log
+// package.
+func stdlibCaller() (file string, line int) {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "log.") && !strings.Contains(frame.Function, "(*stdlibWriter)") {
+			return frame.File, frame.Line
+		}
+		if !more {
+			return "", 0
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package logcap
+
+import (
+	"log"
+
+	"github.com/sirupsen/logrus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StdlibWriter", func() {
+	var (
+		logHook *LogCap
+		logger  *log.Logger
+	)
+	BeforeEach(func() {
+		logHook = NewLogHook(logrus.New())
+		logger = log.New(logHook.StdlibWriter(), "", 0)
+	})
+	AfterEach(func() {
+		Ω(logHook).Should(HaveNoLogs())
+	})
+	It("captures log lines as info entries", func() {
+		logger.Print("connected")
+		logger.Printf("took %dms", 12)
+		Ω(logHook).Should(HaveLogs("connected", "took 12ms", logrus.InfoLevel))
+	})
+	It("splits writes on newlines", func() {
+		logger.Print("one\ntwo")
+		w := logHook.StdlibWriter()
+		w.Write([]byte("par"))
+		Ω(logHook.Count()).Should(Equal(2))
+		w.Write([]byte("tial\nnext"))
+		w.Write([]byte("\n"))
+		Ω(logHook).Should(HaveLogsInOrder("one", "two", "partial", "next"))
+	})
+	It("keeps the log package's prefix", func() {
+		logger.SetPrefix("[api] ")
+		logger.Print("ready")
+		Ω(logHook).Should(HaveLogs("[api] ready"))
+	})
+	It("records the call site", func() {
+		logger.Println("here")
+		records := logHook.EntriesWithSource()
+		Ω(records).Should(HaveLen(1))
+		Ω(records[0].Source).Should(ContainSubstring("stdlib_test.go:"))
+		Ω(logHook).Should(HaveLogs("here"))
+	})
+})
@@ -0,0 +1,1273 @@
+package logcap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onsi/gomega/types"
+	"github.com/sirupsen/logrus"
+)
+
+// The matchers in this file look at the whole capture at once rather
+// than waiting for particular logs to arrive. They check whatever has
+// been logged by the time they run, matched or not, and they don't
+// mark entries as matched, so a later HaveNoLogs() still expects the
+// entries to be accounted for with HaveLogs().
+
+// describe renders a string or matcher argument for failure messages.
+func describe(arg interface{}) string {
+	if s, ok := arg.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("%v", arg)
+}
+
+// describeAll renders a list of string or matcher arguments.
+func describeAll(args []interface{}) string {
+	var descriptions []string
+	for _, arg := range args {
+		descriptions = append(descriptions, describe(arg))
+	}
+	return "[" + strings.Join(descriptions, ", ") + "]"
+}
+
+// loggedAt gives the call site of an entry for failure messages,
+// along with the calling function if Logrus reported it.
+func loggedAt(entry *markedEntry) string {
+	if entry.caller != "" {
+		return fmt.Sprintf("%s:%d (%s)", entry.Data["file"], entry.Data["line"], entry.caller)
+	}
+	return fmt.Sprintf("%s:%d", entry.Data["file"], entry.Data["line"])
+}
+
+type schemaMatcher struct {
+	message  interface{}
+	expected types.GomegaMatcher
+	schemas  map[string][]*markedEntry
+	order    []string
+}
+
+// HaveConsistentFieldSchema collects every captured entry whose
+// message matches the given string or matcher and makes sure they all
+// carry the same set of field keys. This catches code paths that
+// sometimes leave a field off:
+//
+//   Ω(logHook).Should(HaveConsistentFieldSchema("request handled"))
+//
+// Values aren't compared, only keys. It fails if no entries match.
+func HaveConsistentFieldSchema(message interface{}) types.GomegaMatcher {
+	return &schemaMatcher{
+		message:  message,
+		expected: matcherOrEqual(message).Expected,
+	}
+}
+
+func (m *schemaMatcher) Match(actual interface{}) (success bool, err error) {
+	hook := actual.(*LogCap)
+	m.schemas = map[string][]*markedEntry{}
+	m.order = nil
+	for _, entry := range hook.snapshot() {
+		ok, err := m.expected.Match(entry.Message)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		var keys []string
+		for key := range hook.userFields(entry.Data) {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		schema := "[" + strings.Join(keys, " ") + "]"
+		if _, ok := m.schemas[schema]; !ok {
+			m.order = append(m.order, schema)
+		}
+		m.schemas[schema] = append(m.schemas[schema], entry)
+	}
+	return len(m.schemas) == 1, nil
+}
+
+func (m *schemaMatcher) FailureMessage(actual interface{}) (message string) {
+	if len(m.schemas) == 0 {
+		return fmt.Sprintf("Expected logs matching %s, found none", describe(m.message))
+	}
+	message = fmt.Sprintf("Expected logs matching %s to share one field schema. Instead, got %d:", describe(m.message), len(m.schemas))
+	for _, schema := range m.order {
+		entries := m.schemas[schema]
+		message += fmt.Sprintf("\n  %s in %d entries, first logged at %s", schema, len(entries), loggedAt(entries[0]))
+	}
+	return
+}
+
+func (m *schemaMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs matching %s not to share one field schema, but all had %s", describe(m.message), m.order[0])
+}
+
+type distributionMatcher struct {
+	field    string
+	expected map[interface{}]float64
+	tol      float64
+	hook     *LogCap
+	actual   map[interface{}]float64
+	total    int
+}
+
+// HaveFieldValueDistribution tallies the values of field across every
+// captured entry that has it and compares the fraction of entries
+// holding each value against the expected proportions, allowing each
+// to be off by up to tol. Values that aren't in expected must stay
+// within tol of zero. This suits checking weighted or random
+// selection through the logs it leaves:
+//
+//   Ω(logHook).Should(HaveFieldValueDistribution("backend",
+//   	map[interface{}]float64{"primary": 0.8, "replica": 0.2}, 0.05))
+//
+// Values that can't be map keys, such as slices and maps, are tallied
+// by their %#v rendering. They can't be expected, so each counts as an
+// unexpected value.
+func HaveFieldValueDistribution(field string, expected map[interface{}]float64, tol float64) types.GomegaMatcher {
+	return &distributionMatcher{field: field, expected: expected, tol: tol}
+}
+
+func (m *distributionMatcher) Match(actual interface{}) (success bool, err error) {
+	hook := actual.(*LogCap)
+	m.hook = hook
+	counts := map[interface{}]int{}
+	m.total = 0
+	for _, entry := range hook.snapshot() {
+		if value, ok := entry.Data[m.field]; ok {
+			if !hashable(value) {
+				value = renderedValue(fmt.Sprintf("%#v", value))
+			}
+			counts[value]++
+			m.total++
+		}
+	}
+	if m.total == 0 {
+		return false, nil
+	}
+	m.actual = map[interface{}]float64{}
+	for value, count := range counts {
+		m.actual[value] = float64(count) / float64(m.total)
+	}
+	for value, fraction := range m.expected {
+		if math.Abs(m.actual[value]-fraction) > m.tol {
+			return false, nil
+		}
+	}
+	for value, fraction := range m.actual {
+		if _, ok := m.expected[value]; !ok && fraction > m.tol {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// renderedValue stands in for a field value that can't be a map key.
+type renderedValue string
+
+// hashable reports whether v can be used as a map key.
+func hashable(v interface{}) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	_ = map[interface{}]bool{v: true}
+	return true
+}
+
+// distribution renders proportions sorted by value for stable output.
+// The values of a redacted field are shown as "***".
+func distribution(d map[interface{}]float64, redact bool) string {
+	var lines []string
+	for value, fraction := range d {
+		if redact {
+			value = "***"
+		}
+		lines = append(lines, fmt.Sprintf("\n    %v: %.3f", value, fraction))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+func (m *distributionMatcher) FailureMessage(actual interface{}) (message string) {
+	if m.total == 0 {
+		return fmt.Sprintf("Expected logs with a %q field, found none", m.field)
+	}
+	redact := m.hook.redact[m.field]
+	return fmt.Sprintf("Expected %q values across %d entries to be distributed within %v of:%s\n  Instead, got:%s",
+		m.field, m.total, m.tol, distribution(m.expected, redact), distribution(m.actual, redact))
+}
+
+func (m *distributionMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	redact := m.hook.redact[m.field]
+	return fmt.Sprintf("Expected %q values across %d entries not to be distributed within %v of:%s\n  Got:%s",
+		m.field, m.total, m.tol, distribution(m.expected, redact), distribution(m.actual, redact))
+}
+
+type roundTripMatcher struct {
+	formatter logrus.Formatter
+	failures  []string
+}
+
+// HaveFormatterRoundTrip formats every captured entry with the given
+// formatter, parses the output back as JSON and checks that each
+// field the application logged comes back with an equal value. This
+// catches formatters that drop, rename or mangle fields:
+//
+//   Ω(logHook).Should(HaveFormatterRoundTrip(&logrus.JSONFormatter{}))
+//
+// Values are compared after a trip through encoding/json, so an int
+// field matches the float64 the parser hands back. Extra keys in the
+// output (time, level, msg and so on) are ignored.
+func HaveFormatterRoundTrip(f logrus.Formatter) types.GomegaMatcher {
+	return &roundTripMatcher{formatter: f}
+}
+
+func (m *roundTripMatcher) Match(actual interface{}) (success bool, err error) {
+	hook := actual.(*LogCap)
+	m.failures = nil
+	for _, entry := range hook.snapshot() {
+		logged := *entry.Entry
+		logged.Data = appFields(entry.Data)
+		if problem := roundTrip(m.formatter, &logged, hook.redact); problem != "" {
+			m.failures = append(m.failures, fmt.Sprintf("%q logged at %s: %s", entry.Message, loggedAt(entry), problem))
+		}
+	}
+	return len(m.failures) == 0, nil
+}
+
+// roundTrip formats and re-parses one entry, describing any field
+// that didn't survive. Values of the redact keys are shown as "***".
+func roundTrip(f logrus.Formatter, entry *logrus.Entry, redact map[string]bool) string {
+	out, err := f.Format(entry)
+	if err != nil {
+		return fmt.Sprintf("format failed: %v", err)
+	}
+	parsed := map[string]interface{}{}
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return fmt.Sprintf("output isn't JSON: %v", err)
+	}
+	var keys []string
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var problems []string
+	for _, key := range keys {
+		got, ok := parsed[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%q missing", key))
+			continue
+		}
+		want, err := jsonNormalize(entry.Data[key])
+		if err != nil || !reflect.DeepEqual(want, got) {
+			was := entry.Data[key]
+			if redact[key] {
+				was, got = "***", "***"
+			}
+			problems = append(problems, fmt.Sprintf("%q was %#v, came back %#v", key, was, got))
+		}
+	}
+	return strings.Join(problems, ", ")
+}
+
+// jsonNormalize puts a value through encoding/json so it can be
+// compared with a parsed value.
+func jsonNormalize(v interface{}) (normal interface{}, err error) {
+	if e, ok := v.(error); ok { // Logrus formatters log errors by message.
+		v = e.Error()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &normal)
+	return
+}
+
+func (m *roundTripMatcher) FailureMessage(actual interface{}) (message string) {
+	message = fmt.Sprintf("Expected fields to survive a round trip through %T. Instead, got %d failures:", m.formatter, len(m.failures))
+	for _, failure := range m.failures {
+		message += "\n  " + failure
+	}
+	return
+}
+
+func (m *roundTripMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected some fields not to survive a round trip through %T, but they all did", m.formatter)
+}
+
+type groupedMatcher struct {
+	field string
+	hook  *LogCap
+	split *markedEntry // Entry that reopened an earlier group
+	prev  *markedEntry // Entry just before it in the group sequence
+}
+
+// HaveLogsGroupedBy checks that captured entries sharing a value for
+// field were logged together, with no entries from another group in
+// between. Entries without the field are skipped. This suits code
+// that batches its logging by tenant, request or the like:
+//
+//   Ω(logHook).Should(HaveLogsGroupedBy("tenant"))
+func HaveLogsGroupedBy(field string) types.GomegaMatcher {
+	return &groupedMatcher{field: field}
+}
+
+func (m *groupedMatcher) Match(actual interface{}) (success bool, err error) {
+	hook := actual.(*LogCap)
+	m.hook = hook
+	m.split, m.prev = nil, nil
+	closed := map[string]bool{}
+	for _, entry := range hook.snapshot() {
+		if _, ok := entry.Data[m.field]; !ok {
+			continue
+		}
+		// Compare by rendering so that values of any type can be grouped.
+		value := fmt.Sprintf("%#v", entry.Data[m.field])
+		if m.prev != nil {
+			prevValue := fmt.Sprintf("%#v", m.prev.Data[m.field])
+			if prevValue != value {
+				if closed[value] {
+					m.split = entry
+					return false, nil
+				}
+				closed[prevValue] = true
+			}
+		}
+		m.prev = entry
+	}
+	return true, nil
+}
+
+func (m *groupedMatcher) FailureMessage(actual interface{}) (message string) {
+	value, prevValue := m.split.Data[m.field], m.prev.Data[m.field]
+	if m.hook.redact[m.field] {
+		value, prevValue = "***", "***"
+	}
+	return fmt.Sprintf("Expected logs to be grouped by %q. Instead, %s=%v came back after %s=%v:\n  %q logged at %s\n  followed %q logged at %s",
+		m.field, m.field, value, m.field, prevValue,
+		m.split.Message, loggedAt(m.split), m.prev.Message, loggedAt(m.prev))
+}
+
+func (m *groupedMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs not to be grouped by %q, but they were", m.field)
+}
+
+type spanMatcher struct {
+	limit time.Duration
+	span  time.Duration
+}
+
+// HaveCaptureSpanUnder checks that everything captured so far was
+// logged within the given duration, as measured by CaptureSpan().
+// This is a quick way to hold a logged sequence of operations to a
+// time budget:
+//
+//   Ω(logHook).Should(HaveCaptureSpanUnder(time.Millisecond * 500))
+//
+// An empty capture has a zero span.
+func HaveCaptureSpanUnder(d time.Duration) types.GomegaMatcher {
+	return &spanMatcher{limit: d}
+}
+
+func (m *spanMatcher) Match(actual interface{}) (success bool, err error) {
+	m.span = actual.(*LogCap).CaptureSpan()
+	return m.span < m.limit, nil
+}
+
+func (m *spanMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs to span less than %v. Instead, they spanned %v", m.limit, m.span)
+}
+
+func (m *spanMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs to span at least %v. Instead, they spanned %v", m.limit, m.span)
+}
+
+type diffMatcher struct {
+	expected []string
+	diff     string
+}
+
+// MatchMessagesDiff checks that the messages captured so far are
+// exactly the expected list, in order. On failure it shows the
+// report from Diff().
+//
+//   Ω(logHook).Should(MatchMessagesDiff([]string{"connecting", "connected", "ready"}))
+func MatchMessagesDiff(expected []string) types.GomegaMatcher {
+	return &diffMatcher{expected: expected}
+}
+
+func (m *diffMatcher) Match(actual interface{}) (success bool, err error) {
+	m.diff = actual.(*LogCap).Diff(m.expected)
+	return m.diff == "", nil
+}
+
+func (m *diffMatcher) FailureMessage(actual interface{}) (message string) {
+	return "Expected captured messages to match:\n" + m.diff
+}
+
+func (m *diffMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected captured messages not to be %q", m.expected)
+}
+
+type noRepeatMatcher struct {
+	message     interface{}
+	expected    types.GomegaMatcher
+	window      time.Duration
+	first, next *markedEntry
+}
+
+// HaveNoRepeatWithin finds every captured entry whose message matches
+// the given string or matcher and fails if any two in a row were
+// logged less than window apart. This verifies debounce and throttle
+// logic:
+//
+//   Ω(logHook).Should(HaveNoRepeatWithin("cache full", time.Second))
+func HaveNoRepeatWithin(m interface{}, window time.Duration) types.GomegaMatcher {
+	return &noRepeatMatcher{
+		message:  m,
+		expected: matcherOrEqual(m).Expected,
+		window:   window,
+	}
+}
+
+func (m *noRepeatMatcher) Match(actual interface{}) (success bool, err error) {
+	m.first, m.next = nil, nil
+	var prev *markedEntry
+	for _, entry := range actual.(*LogCap).snapshot() {
+		ok, err := m.expected.Match(entry.Message)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		if prev != nil && entry.Time.Sub(prev.Time) < m.window {
+			m.first, m.next = prev, entry
+			return false, nil
+		}
+		prev = entry
+	}
+	return true, nil
+}
+
+func (m *noRepeatMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs matching %s to be at least %v apart. Instead, got two %v apart:\n  %q logged at %s\n  %q logged at %s",
+		describe(m.message), m.window, m.next.Time.Sub(m.first.Time),
+		m.first.Message, loggedAt(m.first), m.next.Message, loggedAt(m.next))
+}
+
+func (m *noRepeatMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs matching %s to repeat within %v, but they didn't", describe(m.message), m.window)
+}
+
+type escalationMatcher struct {
+	messages []interface{}
+	found    []*markedEntry
+	drop     int // Index into found of the entry less severe than the one before, or 0
+}
+
+// HaveEscalatingLevels finds captured entries matching the given
+// strings/matchers in the order given, and checks that each one was
+// logged at the same level as the one before it or a more severe one
+// (info, then warning, then error, say):
+//
+//   Ω(logHook).Should(HaveEscalatingLevels("retrying", "still failing", "giving up"))
+//
+// It fails if the messages can't all be found in order.
+func HaveEscalatingLevels(messages ...interface{}) types.GomegaMatcher {
+	return &escalationMatcher{messages: messages}
+}
+
+func (m *escalationMatcher) Match(actual interface{}) (success bool, err error) {
+	m.found, m.drop = nil, 0
+	entries := actual.(*LogCap).snapshot()
+	cursor := 0
+	for _, message := range m.messages {
+		expected := matcherOrEqual(message).Expected
+		for ; cursor < len(entries); cursor++ {
+			ok, err := expected.Match(entries[cursor].Message)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				break
+			}
+		}
+		if cursor == len(entries) {
+			return false, nil
+		}
+		m.found = append(m.found, entries[cursor])
+		cursor++
+	}
+	for i := 1; i < len(m.found); i++ {
+		// Logrus levels count down as severity goes up.
+		if m.found[i].Level > m.found[i-1].Level {
+			m.drop = i
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *escalationMatcher) FailureMessage(actual interface{}) (message string) {
+	if len(m.found) < len(m.messages) {
+		return fmt.Sprintf("Expected logs matching %s in order. Instead, couldn't find %s after %d of them",
+			describeAll(m.messages), describe(m.messages[len(m.found)]), len(m.found))
+	}
+	prev, next := m.found[m.drop-1], m.found[m.drop]
+	return fmt.Sprintf("Expected escalating levels. Instead, %s dropped to %s:\n  %q logged at %s\n  %q logged at %s",
+		prev.Level, next.Level, prev.Message, loggedAt(prev), next.Message, loggedAt(next))
+}
+
+func (m *escalationMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs matching %s not to escalate, but they did", describeAll(m.messages))
+}
+
+// unformattedRegexp finds what look like printf verbs in a message,
+// along with the %!verb(...) markers fmt leaves for missing or bad
+// arguments. A percent sign followed by a space ("50% done") or ending
+// the message isn't a verb. unformatted() weeds out the rest of the
+// look-alikes.
+var unformattedRegexp = regexp.MustCompile(`%(![a-zA-Z]?\(|[-+#0]*(\d+|\*)?(\.(\d+|\*)?)?[vTtbcdoOqxXUeEfFgGsp])`)
+
+// unformatted reports whether a message still holds a printf verb or
+// a %!verb(...) marker. A verb run straight into a letter or digit
+// ("10%off", "%20bob") is taken for plain text unless it has a
+// precision, and so is a single digit and a hex letter ("%2F"), which
+// is how URLs encode bytes.
+func unformatted(message string) bool {
+	for _, loc := range unformattedRegexp.FindAllStringSubmatchIndex(message, -1) {
+		match := message[loc[0]:loc[1]]
+		switch {
+		case strings.HasPrefix(match, "%!"), loc[6] >= 0: // Marker or precision
+			return true
+		case len(match) == 3 && match[1] >= '0' && match[1] <= '9' && strings.ContainsRune("abcdefABCDEF", rune(match[2])):
+			continue // Percent-encoded byte
+		case loc[1] < len(message) && isAlphanumeric(message[loc[1]]):
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// isAlphanumeric reports whether b is an ASCII letter or digit.
+func isAlphanumeric(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+type unformattedMatcher struct {
+	offenders []*markedEntry
+}
+
+// HaveNoUnformattedLogs fails if any captured message still contains
+// printf verbs such as %s, %d or %v, a sign that a format string was
+// logged with Info() instead of Infof() or was missing its arguments:
+//
+//   Ω(logHook).Should(HaveNoUnformattedLogs())
+//
+// Plain percent signs, as in "50% done" or "at 100%", are fine, and so
+// are percent-encoded URLs and text run up against a percent sign
+// ("10%off"). That means a verb like "%dms" or "%5d" goes unnoticed,
+// though "%d ms" and "%.2fs" are caught.
+func HaveNoUnformattedLogs() types.GomegaMatcher {
+	return &unformattedMatcher{}
+}
+
+func (m *unformattedMatcher) Match(actual interface{}) (success bool, err error) {
+	m.offenders = nil
+	for _, entry := range actual.(*LogCap).snapshot() {
+		if unformatted(entry.Message) {
+			m.offenders = append(m.offenders, entry)
+		}
+	}
+	return len(m.offenders) == 0, nil
+}
+
+func (m *unformattedMatcher) FailureMessage(actual interface{}) (message string) {
+	message = fmt.Sprintf("Expected no unformatted logs. Instead, got %d:", len(m.offenders))
+	for _, entry := range m.offenders {
+		message += fmt.Sprintf("\n  %s\n  logged at %s", entry.Message, loggedAt(entry))
+	}
+	return
+}
+
+func (m *unformattedMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return "Expected some unformatted logs, but found none"
+}
+
+type stateMachineMatcher struct {
+	transitions map[string][]string
+	field       string
+	hook        *LogCap
+	from, to    *markedEntry
+}
+
+// HaveLogStateMachine treats the value of field on successive captured
+// entries as states and checks that every change of state is allowed
+// by transitions, which maps each state to the states it may move to.
+// Entries without the field are skipped, and logging the same state
+// again isn't a transition:
+//
+//   Ω(logHook).Should(HaveLogStateMachine(map[string][]string{
+//   	"idle":       {"connecting"},
+//   	"connecting": {"connected", "idle"},
+//   	"connected":  {"idle"},
+//   }, "state"))
+//
+// Values are compared by their fmt.Sprint() form.
+func HaveLogStateMachine(transitions map[string][]string, field string) types.GomegaMatcher {
+	return &stateMachineMatcher{transitions: transitions, field: field}
+}
+
+func (m *stateMachineMatcher) Match(actual interface{}) (success bool, err error) {
+	m.hook = actual.(*LogCap)
+	m.from, m.to = nil, nil
+	var prev *markedEntry
+EntryLoop:
+	for _, entry := range m.hook.snapshot() {
+		value, ok := entry.Data[m.field]
+		if !ok {
+			continue
+		}
+		state := fmt.Sprint(value)
+		if prev != nil {
+			prevState := fmt.Sprint(prev.Data[m.field])
+			if state != prevState {
+				for _, allowed := range m.transitions[prevState] {
+					if allowed == state {
+						prev = entry
+						continue EntryLoop
+					}
+				}
+				m.from, m.to = prev, entry
+				return false, nil
+			}
+		}
+		prev = entry
+	}
+	return true, nil
+}
+
+func (m *stateMachineMatcher) FailureMessage(actual interface{}) (message string) {
+	from, to := fmt.Sprint(m.from.Data[m.field]), fmt.Sprint(m.to.Data[m.field])
+	allowed := m.transitions[from]
+	if m.hook.redact[m.field] {
+		from, to = "***", "***"
+	}
+	return fmt.Sprintf("Expected %q to follow the allowed transitions. Instead, it went from %q to %q (allowed: %q):\n  %q logged at %s\n  %q logged at %s",
+		m.field, from, to, allowed, m.from.Message, loggedAt(m.from), m.to.Message, loggedAt(m.to))
+}
+
+func (m *stateMachineMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected %q to make an illegal transition, but all were allowed", m.field)
+}
+
+type errorChainMatcher struct {
+	messages []string
+	chains   []string // Rendered chains of the entries that had errors
+}
+
+// HaveLoggedErrorChain looks for a captured entry whose error field
+// (as set by WithError()) unwraps, via errors.Unwrap(), into a chain
+// containing the given substrings in order, each in a deeper link of
+// the chain than the one before:
+//
+//   err := fmt.Errorf("save: %w", fmt.Errorf("write: %w", io.ErrShortWrite))
+//   logrus.WithError(err).Error("failed")
+//   Ω(logHook).Should(HaveLoggedErrorChain("save", "write", "short write"))
+//
+// This checks that errors are wrapped, not just formatted, on their
+// way to the log.
+func HaveLoggedErrorChain(messages ...string) types.GomegaMatcher {
+	return &errorChainMatcher{messages: messages}
+}
+
+// chainHas reports whether the messages can be found in order, each
+// one in a deeper link of the chain than the last.
+func chainHas(err error, messages []string) bool {
+	for _, message := range messages {
+		for err != nil && !strings.Contains(err.Error(), message) {
+			err = errors.Unwrap(err)
+		}
+		if err == nil {
+			return false
+		}
+		err = errors.Unwrap(err)
+	}
+	return true
+}
+
+func (m *errorChainMatcher) Match(actual interface{}) (success bool, err error) {
+	m.chains = nil
+	for _, entry := range actual.(*LogCap).snapshot() {
+		logged, ok := entry.Data[logrus.ErrorKey].(error)
+		if !ok {
+			continue
+		}
+		if chainHas(logged, m.messages) {
+			return true, nil
+		}
+		var links []string
+		for e := logged; e != nil; e = errors.Unwrap(e) {
+			links = append(links, strconv.Quote(e.Error()))
+		}
+		m.chains = append(m.chains, fmt.Sprintf("%s\n    logged at %s", strings.Join(links, " -> "), loggedAt(entry)))
+	}
+	return false, nil
+}
+
+func (m *errorChainMatcher) FailureMessage(actual interface{}) (message string) {
+	message = fmt.Sprintf("Expected a logged error chain containing %q in order. Instead, got %d error chains:", m.messages, len(m.chains))
+	for _, chain := range m.chains {
+		message += "\n  " + chain
+	}
+	return
+}
+
+func (m *errorChainMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected no logged error chain containing %q in order, but found one", m.messages)
+}
+
+type callSitesMatcher struct {
+	n     int
+	sites map[string]int
+	order []string
+}
+
+// HaveDistinctCallSites checks that everything captured so far came
+// from at least n distinct call sites (file:line pairs). This shows
+// that logging is spread across the code under test rather than all
+// going through one wrapper:
+//
+//   Ω(logHook).Should(HaveDistinctCallSites(3))
+//
+// If all logs seem to come from one place, IgnoreCaller() can skip the
+// wrapper so the real call sites are recorded.
+func HaveDistinctCallSites(n int) types.GomegaMatcher {
+	return &callSitesMatcher{n: n}
+}
+
+func (m *callSitesMatcher) Match(actual interface{}) (success bool, err error) {
+	m.sites = map[string]int{}
+	m.order = nil
+	for _, entry := range actual.(*LogCap).snapshot() {
+		site := loggedAt(entry)
+		if m.sites[site] == 0 {
+			m.order = append(m.order, site)
+		}
+		m.sites[site]++
+	}
+	return len(m.sites) >= m.n, nil
+}
+
+// siteList renders the call sites seen along with how many logs each
+// made.
+func (m *callSitesMatcher) siteList() (list string) {
+	for _, site := range m.order {
+		list += fmt.Sprintf("\n  %s (%d logs)", site, m.sites[site])
+	}
+	return
+}
+
+func (m *callSitesMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs from at least %d call sites. Instead, got %d:%s", m.n, len(m.sites), m.siteList())
+}
+
+func (m *callSitesMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs from fewer than %d call sites. Instead, got %d:%s", m.n, len(m.sites), m.siteList())
+}
+
+type highWaterMatcher struct {
+	limit int
+	mark  int
+}
+
+// HaveBufferHighWaterUnder checks that the hook's internal buffer has
+// never held n or more entries at once, as measured by
+// HighWaterMark(). This shows how much headroom the entryCount given
+// to NewLogHook() leaves:
+//
+//   Ω(logHook).Should(HaveBufferHighWaterUnder(800))
+func HaveBufferHighWaterUnder(n int) types.GomegaMatcher {
+	return &highWaterMatcher{limit: n}
+}
+
+func (m *highWaterMatcher) Match(actual interface{}) (success bool, err error) {
+	m.mark = actual.(*LogCap).HighWaterMark()
+	return m.mark < m.limit, nil
+}
+
+func (m *highWaterMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected the buffer to hold fewer than %d entries at once. Instead, it held %d", m.limit, m.mark)
+}
+
+func (m *highWaterMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected the buffer to hold at least %d entries at once. Instead, it held at most %d", m.limit, m.mark)
+}
+
+type fingerprintMatcher struct {
+	expected string
+	actual   string
+}
+
+// MatchFingerprint checks that the capture's Fingerprint() is the
+// expected one. Record the fingerprint of a known-good run and any
+// change to the logged messages or fields will show up as a mismatch:
+//
+//   Ω(logHook).Should(MatchFingerprint("5d41402abc4b2a76..."))
+//
+// Use Diff() or EntriesWithSource() to see what changed.
+func MatchFingerprint(expected string) types.GomegaMatcher {
+	return &fingerprintMatcher{expected: expected}
+}
+
+func (m *fingerprintMatcher) Match(actual interface{}) (success bool, err error) {
+	m.actual = actual.(*LogCap).Fingerprint()
+	return m.actual == m.expected, nil
+}
+
+func (m *fingerprintMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs to have fingerprint\n  %s\nInstead, they have\n  %s", m.expected, m.actual)
+}
+
+func (m *fingerprintMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs not to have fingerprint\n  %s", m.expected)
+}
+
+type alwaysFieldMatcher struct {
+	message  interface{}
+	expected types.GomegaMatcher
+	field    string
+	matching int
+	lacking  []*markedEntry
+}
+
+// HaveLogsAlwaysWithField checks that every captured entry whose
+// message matches the given string or matcher carries the field. This
+// enforces a mandatory-field policy:
+//
+//   Ω(logHook).Should(HaveLogsAlwaysWithField("payment processed", "transaction_id"))
+//
+// The field's value isn't checked. It succeeds if no entries match.
+func HaveLogsAlwaysWithField(message interface{}, field string) types.GomegaMatcher {
+	return &alwaysFieldMatcher{
+		message:  message,
+		expected: matcherOrEqual(message).Expected,
+		field:    field,
+	}
+}
+
+func (m *alwaysFieldMatcher) Match(actual interface{}) (success bool, err error) {
+	m.matching = 0
+	m.lacking = nil
+	for _, entry := range actual.(*LogCap).snapshot() {
+		ok, err := m.expected.Match(entry.Message)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		m.matching++
+		if _, ok := entry.Data[m.field]; !ok {
+			m.lacking = append(m.lacking, entry)
+		}
+	}
+	return len(m.lacking) == 0, nil
+}
+
+func (m *alwaysFieldMatcher) FailureMessage(actual interface{}) (message string) {
+	hook := actual.(*LogCap)
+	message = fmt.Sprintf("Expected every log matching %s to have a %q field. Instead, %d of %d didn't:", describe(m.message), m.field, len(m.lacking), m.matching)
+	for _, entry := range m.lacking {
+		extra := ""
+		if data := hook.userFields(entry.Data); len(data) > 0 {
+			extra = fmt.Sprintf(" (%v)", data)
+		}
+		message += fmt.Sprintf("\n  %s%s\n  logged at %s", entry.Message, extra, loggedAt(entry))
+	}
+	return
+}
+
+func (m *alwaysFieldMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected some log matching %s to lack a %q field, but all %d had it", describe(m.message), m.field, m.matching)
+}
+
+type deadlineMatcher struct {
+	count int
+}
+
+// HaveLogWithDeadlineExceeded checks that some captured entry was
+// logged with a context (through WithContext()) whose deadline has
+// passed:
+//
+//   ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+//   defer cancel()
+//   fetch(ctx) // logs with logrus.WithContext(ctx) when it times out
+//   Ω(logHook).Should(HaveLogWithDeadlineExceeded())
+//
+// The context is checked when the matcher runs, not when the entry
+// was logged. Entries without a context never match.
+func HaveLogWithDeadlineExceeded() types.GomegaMatcher {
+	return &deadlineMatcher{}
+}
+
+func (m *deadlineMatcher) Match(actual interface{}) (success bool, err error) {
+	m.count = 0
+	for _, entry := range actual.(*LogCap).snapshot() {
+		if entry.Context != nil && errors.Is(entry.Context.Err(), context.DeadlineExceeded) {
+			m.count++
+		}
+	}
+	return m.count > 0, nil
+}
+
+func (m *deadlineMatcher) FailureMessage(actual interface{}) (message string) {
+	return "Expected a log with an exceeded context deadline, found none"
+}
+
+func (m *deadlineMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected no logs with an exceeded context deadline. Instead, got %d", m.count)
+}
+
+type interleavedMatcher struct {
+	a, b     interface{}
+	expectA  types.GomegaMatcher
+	expectB  types.GomegaMatcher
+	count    int
+	broken   *markedEntry // First entry out of turn
+	wantedA  bool         // Whether an a was due there
+	previous *markedEntry
+}
+
+// HaveInterleavedLogs checks that the captured entries matching a and
+// the ones matching b (strings or matchers) strictly take turns,
+// starting with a: a, b, a, b and so on. Other logs may come in
+// between. This shows a producer and consumer handing off properly:
+//
+//   Ω(logHook).Should(HaveInterleavedLogs("produced", "consumed"))
+//
+// An entry matching both counts as a. It fails if no entries match.
+func HaveInterleavedLogs(a, b interface{}) types.GomegaMatcher {
+	return &interleavedMatcher{
+		a:       a,
+		b:       b,
+		expectA: matcherOrEqual(a).Expected,
+		expectB: matcherOrEqual(b).Expected,
+	}
+}
+
+func (m *interleavedMatcher) Match(actual interface{}) (success bool, err error) {
+	m.count = 0
+	m.broken, m.previous = nil, nil
+	for _, entry := range actual.(*LogCap).snapshot() {
+		isA, err := m.expectA.Match(entry.Message)
+		if err != nil {
+			return false, err
+		}
+		isB := false
+		if !isA {
+			if isB, err = m.expectB.Match(entry.Message); err != nil {
+				return false, err
+			}
+		}
+		if !isA && !isB {
+			continue
+		}
+		m.wantedA = m.count%2 == 0
+		if isA != m.wantedA {
+			m.broken = entry
+			return false, nil
+		}
+		m.count++
+		m.previous = entry
+	}
+	return m.count > 0, nil
+}
+
+func (m *interleavedMatcher) FailureMessage(actual interface{}) (message string) {
+	if m.broken == nil {
+		return fmt.Sprintf("Expected logs matching %s and %s to alternate, found none", describe(m.a), describe(m.b))
+	}
+	want := describe(m.a)
+	if !m.wantedA {
+		want = describe(m.b)
+	}
+	message = fmt.Sprintf("Expected logs matching %s and %s to alternate. Instead, after %d in turn, expected %s but got %q\n    logged at %s",
+		describe(m.a), describe(m.b), m.count, want, m.broken.Message, loggedAt(m.broken))
+	if m.previous != nil {
+		message += fmt.Sprintf("\nright after %q\n    logged at %s", m.previous.Message, loggedAt(m.previous))
+	}
+	return
+}
+
+func (m *interleavedMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs matching %s and %s not to alternate, but all %d did", describe(m.a), describe(m.b), m.count)
+}
+
+type errorStackMatcher struct {
+	field   string
+	errors  int
+	lacking []*markedEntry
+}
+
+// HaveErrorLogsWithStack checks that every captured error-level entry
+// carries a non-empty stack trace in the given field, so errors are
+// logged with enough context to debug them:
+//
+//   Ω(logHook).Should(HaveErrorLogsWithStack("stack"))
+//
+// A field holding nil, an empty string or an empty slice counts as
+// missing. It succeeds if no errors were logged.
+func HaveErrorLogsWithStack(stackField string) types.GomegaMatcher {
+	return &errorStackMatcher{field: stackField}
+}
+
+func (m *errorStackMatcher) Match(actual interface{}) (success bool, err error) {
+	m.errors = 0
+	m.lacking = nil
+	for _, entry := range actual.(*LogCap).snapshot() {
+		if entry.Level != logrus.ErrorLevel {
+			continue
+		}
+		m.errors++
+		if isEmptyValue(entry.Data[m.field]) {
+			m.lacking = append(m.lacking, entry)
+		}
+	}
+	return len(m.lacking) == 0, nil
+}
+
+// isEmptyValue reports whether a field value is nil or has nothing in
+// it.
+func isEmptyValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch value := reflect.ValueOf(v); value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+	return false
+}
+
+func (m *errorStackMatcher) FailureMessage(actual interface{}) (message string) {
+	message = fmt.Sprintf("Expected every error log to have a %q field. Instead, %d of %d didn't:", m.field, len(m.lacking), m.errors)
+	for _, entry := range m.lacking {
+		message += fmt.Sprintf("\n  %s\n  logged at %s", entry.Message, loggedAt(entry))
+	}
+	return
+}
+
+func (m *errorStackMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected some error log to lack a %q field, but all %d had it", m.field, m.errors)
+}
+
+type gateMatcher struct {
+	gate      interface{}
+	expected  types.GomegaMatcher
+	found     bool
+	premature []*markedEntry
+}
+
+// HaveNoLogsBefore checks that nothing was logged ahead of the first
+// entry matching gate (a string or matcher), for initialization-order
+// tests:
+//
+//   Ω(logHook).Should(HaveNoLogsBefore("service ready"))
+//
+// It fails if no entry matches the gate.
+func HaveNoLogsBefore(gate interface{}) types.GomegaMatcher {
+	return &gateMatcher{gate: gate, expected: matcherOrEqual(gate).Expected}
+}
+
+func (m *gateMatcher) Match(actual interface{}) (success bool, err error) {
+	m.found = false
+	m.premature = nil
+	for _, entry := range actual.(*LogCap).snapshot() {
+		isGate, err := m.expected.Match(entry.Message)
+		if err != nil {
+			return false, err
+		}
+		if isGate {
+			m.found = true
+			break
+		}
+		m.premature = append(m.premature, entry)
+	}
+	return m.found && len(m.premature) == 0, nil
+}
+
+func (m *gateMatcher) FailureMessage(actual interface{}) (message string) {
+	if !m.found {
+		return fmt.Sprintf("Expected a log matching %s, found none", describe(m.gate))
+	}
+	message = fmt.Sprintf("Expected no logs before %s. Instead, got %d:", describe(m.gate), len(m.premature))
+	for _, entry := range m.premature {
+		message += fmt.Sprintf("\n  %s: %s\n  logged at %s", entry.Level, entry.Message, loggedAt(entry))
+	}
+	return
+}
+
+func (m *gateMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected some log before %s, but it came first", describe(m.gate))
+}
+
+type sequenceMatcher struct {
+	sequence []interface{}
+	expected []types.GomegaMatcher
+	found    bool // Some entry matched the start of the sequence
+	after    *markedEntry
+	next     interface{}
+	between  []*markedEntry
+	followed bool // The next expected log turned up later on
+}
+
+// HaveLogSequence checks that entries matching the given strings or
+// matchers were logged back to back, in order, with nothing in
+// between:
+//
+//   Ω(logHook).Should(HaveLogSequence("begin transaction", "update row", "commit"))
+//
+// Unlike HaveLogsInOrder(), any other log in the middle breaks the
+// sequence. The failure message shows what got in the way, starting
+// from the longest run found.
+func HaveLogSequence(sequence ...interface{}) types.GomegaMatcher {
+	m := &sequenceMatcher{sequence: sequence}
+	for _, s := range sequence {
+		m.expected = append(m.expected, matcherOrEqual(s).Expected)
+	}
+	return m
+}
+
+func (m *sequenceMatcher) Match(actual interface{}) (success bool, err error) {
+	m.found, m.after, m.next, m.between, m.followed = false, nil, nil, nil, false
+	if len(m.expected) == 0 {
+		return true, nil
+	}
+	entries := actual.(*LogCap).snapshot()
+	bestStart, bestRun := -1, 0
+	for start := range entries {
+		run := 0
+		for run < len(m.expected) && start+run < len(entries) {
+			ok, err := m.expected[run].Match(entries[start+run].Message)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				break
+			}
+			run++
+		}
+		if run == len(m.expected) {
+			return true, nil
+		}
+		if run > bestRun {
+			bestStart, bestRun = start, run
+		}
+	}
+	if bestRun == 0 {
+		return false, nil
+	}
+	m.found = true
+	m.after = entries[bestStart+bestRun-1]
+	m.next = m.sequence[bestRun]
+	for _, entry := range entries[bestStart+bestRun:] {
+		ok, err := m.expected[bestRun].Match(entry.Message)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			m.followed = true
+			break
+		}
+		m.between = append(m.between, entry)
+	}
+	return false, nil
+}
+
+func (m *sequenceMatcher) FailureMessage(actual interface{}) (message string) {
+	if !m.found {
+		return fmt.Sprintf("Expected logs %s back to back, found none matching %s", describeAll(m.sequence), describe(m.sequence[0]))
+	}
+	message = fmt.Sprintf("Expected logs %s back to back. Instead, after %q\n    logged at %s\n",
+		describeAll(m.sequence), m.after.Message, loggedAt(m.after))
+	if m.followed {
+		message += fmt.Sprintf("got %d before %s:", len(m.between), describe(m.next))
+	} else {
+		message += fmt.Sprintf("%s never followed; got %d:", describe(m.next), len(m.between))
+	}
+	for _, entry := range m.between {
+		message += fmt.Sprintf("\n  %s\n  logged at %s", entry.Message, loggedAt(entry))
+	}
+	return
+}
+
+func (m *sequenceMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected logs %s not to be back to back, but they were", describeAll(m.sequence))
+}
+
+type allFieldsMatcher struct {
+	fields    logrus.Fields
+	match     *logsMatch
+	hook      *LogCap
+	count     int
+	violation *markedEntry
+}
+
+// AllLogsHaveFields checks that every captured entry matches the given
+// fields, for invariants that should hold across a whole test:
+//
+//   Ω(logHook).Should(AllLogsHaveFields(logrus.Fields{"request_id": Not(BeEmpty())}))
+//
+// Fields are compared the same way as in a logrus.Fields{} argument to
+// HaveLogs(), so values may be matchers, nested fields or Absent. It
+// fails at the first entry that doesn't match and succeeds if nothing
+// was logged.
+func AllLogsHaveFields(fields logrus.Fields) types.GomegaMatcher {
+	return &allFieldsMatcher{fields: fields, match: &logsMatch{Fields: &fields}}
+}
+
+func (m *allFieldsMatcher) Match(actual interface{}) (success bool, err error) {
+	m.hook = actual.(*LogCap)
+	entries := m.hook.snapshot()
+	m.count = len(entries)
+	m.violation = nil
+	for _, entry := range entries {
+		ok, err := m.match.fieldsMatch(entry.Data)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			m.violation = entry
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *allFieldsMatcher) FailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected every log to have fields %v. Instead, %q\n    logged at %s\n    has fields %v",
+		m.hook.redacted(m.fields), m.violation.Message, loggedAt(m.violation), m.hook.userFields(m.violation.Data))
+}
+
+func (m *allFieldsMatcher) NegatedFailureMessage(actual interface{}) (message string) {
+	return fmt.Sprintf("Expected some log not to have fields %v, but all %d did", m.hook.redacted(m.fields), m.count)
+}
@@ -0,0 +1,119 @@
+package logcap
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap/zapcore"
+)
+
+// ZapCore returns a zapcore.Core that captures entries into the hook,
+// so the usual matchers work on code that logs with zap:
+//
+//   logHook := NewLogHook()
+//   logger := zap.New(logHook.ZapCore(), zap.AddCaller())
+//   logger.Info("connected", zap.String("host", "db1"))
+//   Ω(logHook).Should(HaveLogs("connected", logrus.Fields{"host": "db1"}))
+//
+// To keep an existing core's output as well, add it with
+// zap.WrapCore() and zapcore.NewTee(). Levels are mapped to the Logrus
+// level of the same name (DPanicLevel becomes PanicLevel) and fields
+// are stored the way zapcore.MapObjectEncoder encodes them, except
+// that integers are stored as int so they compare equal to plain
+// integer literals. The call site comes from the entry's caller, so
+// use zap.AddCaller() to have it recorded.
+//
+// The core is enabled at every level and doesn't need Start().
+// Nothing is written anywhere.
+func (hook *LogCap) ZapCore() zapcore.Core {
+	return &zapCore{hook: hook}
+}
+
+type zapCore struct {
+	hook   *LogCap
+	fields []zapcore.Field // From With
+}
+
+func (c *zapCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *zapCore) With(fields []zapcore.Field) zapcore.Core {
+	return &zapCore{
+		hook:   c.hook,
+		fields: append(append([]zapcore.Field(nil), c.fields...), fields...),
+	}
+}
+
+func (c *zapCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (c *zapCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	data := logrus.Fields{}
+	for k, v := range enc.Fields {
+		data[k] = zapValue(v)
+	}
+	e := &logrus.Entry{
+		Logger:  c.hook.logger,
+		Time:    ent.Time,
+		Level:   zapLevel(ent.Level),
+		Message: ent.Message,
+		Data:    data,
+	}
+	var (
+		file string
+		line int
+	)
+	if ent.Caller.Defined {
+		file, line = ent.Caller.File, ent.Caller.Line
+	}
+	return c.hook.capture(e, file, line, nil)
+}
+
+func (c *zapCore) Sync() error {
+	return nil
+}
+
+// zapValue turns the integers zap encodes as int64 into int, looking
+// into nested objects.
+func zapValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		if int64(int(v)) == v {
+			return int(v)
+		}
+	case map[string]interface{}:
+		for k, nested := range v {
+			v[k] = zapValue(nested)
+		}
+	}
+	return v
+}
+
+// zapLevel maps a zap level to the Logrus level of the same name.
+func zapLevel(level zapcore.Level) logrus.Level {
+	switch level {
+	case zapcore.DebugLevel:
+		return logrus.DebugLevel
+	case zapcore.InfoLevel:
+		return logrus.InfoLevel
+	case zapcore.WarnLevel:
+		return logrus.WarnLevel
+	case zapcore.ErrorLevel:
+		return logrus.ErrorLevel
+	case zapcore.DPanicLevel, zapcore.PanicLevel:
+		return logrus.PanicLevel
+	case zapcore.FatalLevel:
+		return logrus.FatalLevel
+	}
+	if level < zapcore.DebugLevel {
+		return logrus.TraceLevel
+	}
+	return logrus.ErrorLevel
+}
@@ -0,0 +1,65 @@
+package logcap
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+var _ = Describe("ZapCore", func() {
+	var (
+		logHook *LogCap
+		logger  *zap.Logger
+	)
+	BeforeEach(func() {
+		logHook = NewLogHook(logrus.New())
+		logger = zap.New(logHook.ZapCore(), zap.AddCaller())
+	})
+	AfterEach(func() {
+		Ω(logHook).Should(HaveNoLogs())
+	})
+	It("captures zap entries", func() {
+		logger.Info("connected",
+			zap.String("host", "db1"),
+			zap.Int("port", 5432),
+			zap.Bool("tls", true),
+			zap.Duration("took", time.Second),
+			zap.Error(errors.New("boom")))
+		Ω(logHook).Should(HaveLogs("connected", logrus.Fields{
+			"host":  "db1",
+			"port":  5432,
+			"tls":   true,
+			"took":  time.Second,
+			"error": "boom",
+		}))
+	})
+	It("maps zap levels to Logrus levels", func() {
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error")
+		logger.DPanic("dpanic")
+		Ω(logHook).Should(HaveLogs(
+			"debug", logrus.DebugLevel,
+			"info", logrus.InfoLevel,
+			"warn", logrus.WarnLevel,
+			"error", logrus.ErrorLevel,
+			"dpanic", logrus.PanicLevel,
+		))
+	})
+	It("keeps fields added with With", func() {
+		logger.With(zap.String("svc", "api")).Info("ready", zap.Int("workers", 4))
+		Ω(logHook).Should(HaveLogs("ready", logrus.Fields{"svc": "api", "workers": 4}))
+	})
+	It("records the call site", func() {
+		logger.Info("here")
+		records := logHook.EntriesWithSource()
+		Ω(records).Should(HaveLen(1))
+		Ω(records[0].Source).Should(ContainSubstring("zap_test.go:"))
+		Ω(logHook).Should(HaveLogs("here"))
+	})
+})
@@ -0,0 +1,161 @@
+package logcap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ZerologWriter returns an io.Writer that captures the JSON lines
+// zerolog writes, so the usual matchers work on code that logs with
+// zerolog:
+//
+//   logHook := NewLogHook()
+//   logger := zerolog.New(logHook.ZerologWriter()).With().Caller().Logger()
+//   logger.Info().Str("host", "db1").Int("port", 5432).Msg("connected")
+//   Ω(logHook).Should(HaveLogs("connected", logrus.Fields{"host": "db1", "port": 5432}))
+//
+// The level, time, message and caller keys are the zerolog defaults.
+// Levels map to the Logrus level of the same name, and a line without
+// one is captured at info level. A string time is parsed as RFC 3339
+// and a numeric one as Unix seconds; otherwise the capture time is
+// used. The call site comes from the caller key, so add Caller() to
+// the logger to have it recorded. Every other key becomes a field:
+// whole numbers are stored as int so they compare equal to plain
+// integer literals, other numbers as float64, and objects and arrays
+// the way encoding/json decodes them.
+//
+// Writes are split on newlines like StdlibWriter()'s. A line that
+// isn't a JSON object (say, from zerolog.ConsoleWriter) is an error.
+// The writer doesn't need Start(). Nothing is written anywhere.
+//
+// The writer only parses JSON, so this package doesn't import zerolog;
+// the tests log through a real zerolog logger to keep it in step.
+func (hook *LogCap) ZerologWriter() io.Writer {
+	return &zerologWriter{hook: hook}
+}
+
+type zerologWriter struct {
+	hook    *LogCap
+	mut     sync.Mutex
+	partial []byte // Start of a line still waiting for its newline
+}
+
+func (w *zerologWriter) Write(p []byte) (int, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := w.partial[:i]
+		w.partial = w.partial[i+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := w.capture(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// capture decodes one zerolog line and hands it to the hook.
+func (w *zerologWriter) capture(raw []byte) error {
+	var data map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return fmt.Errorf("not a zerolog JSON line %q: %v", raw, err)
+	}
+	e := &logrus.Entry{
+		Logger: w.hook.logger,
+		Time:   time.Now(),
+		Level:  logrus.InfoLevel,
+		Data:   logrus.Fields{},
+	}
+	var (
+		file string
+		line int
+	)
+	for k, v := range data {
+		switch k {
+		case "level":
+			if level, err := logrus.ParseLevel(fmt.Sprint(v)); err == nil {
+				e.Level = level
+			}
+		case "time":
+			if t, ok := zerologTime(v); ok {
+				e.Time = t
+			}
+		case "message":
+			e.Message = fmt.Sprint(v)
+		case "caller":
+			file, line = zerologCaller(fmt.Sprint(v))
+		default:
+			e.Data[k] = zerologValue(v)
+		}
+	}
+	return w.hook.capture(e, file, line, nil)
+}
+
+// zerologValue converts a decoded JSON value to the form fields are
+// stored in.
+func zerologValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil && int64(int(i)) == i {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, nested := range v {
+			v[k] = zerologValue(nested)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = zerologValue(item)
+		}
+	}
+	return v
+}
+
+// zerologTime reads a time written with zerolog's default format or
+// as Unix seconds.
+func zerologTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// zerologCaller splits a file:line caller.
+func zerologCaller(caller string) (file string, line int) {
+	i := strings.LastIndexByte(caller, ':')
+	if i < 0 {
+		return caller, 0
+	}
+	line, err := strconv.Atoi(caller[i+1:])
+	if err != nil {
+		return caller, 0
+	}
+	return caller[:i], line
+}
@@ -0,0 +1,100 @@
+package logcap
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sirupsen/logrus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ZerologWriter", func() {
+	var (
+		logHook *LogCap
+		w       io.Writer
+	)
+	BeforeEach(func() {
+		logHook = NewLogHook(logrus.New())
+		w = logHook.ZerologWriter()
+	})
+	AfterEach(func() {
+		Ω(logHook).Should(HaveNoLogs())
+	})
+	It("captures a zerolog logger's output", func() {
+		logger := zerolog.New(w).With().Timestamp().Caller().Logger()
+		logger.Warn().Str("host", "db1").Int("port", 5432).Float64("ratio", 0.5).Err(io.EOF).Dict("req", zerolog.Dict().Str("method", "GET")).Strs("tags", []string{"a", "b"}).Msg("slow query")
+		_, file, line, _ := runtime.Caller(0)
+		records := logHook.EntriesWithSource()
+		Ω(records).Should(HaveLen(1))
+		Ω(records[0].Source).Should(Equal(fmt.Sprintf("%s:%d", file, line-1)))
+		Ω(records[0].Time).Should(BeTemporally("~", time.Now(), time.Second*2))
+		Ω(logHook).Should(HaveLogs("slow query", logrus.WarnLevel, logrus.Fields{
+			"host":  "db1",
+			"port":  5432,
+			"ratio": 0.5,
+			"error": "EOF",
+			"req":   logrus.Fields{"method": "GET"},
+			"tags":  Equal([]interface{}{"a", "b"}),
+		}))
+	})
+	It("maps a zerolog logger's levels", func() {
+		logger := zerolog.New(w)
+		for _, level := range []zerolog.Level{zerolog.TraceLevel, zerolog.DebugLevel, zerolog.InfoLevel,
+			zerolog.WarnLevel, zerolog.ErrorLevel, zerolog.FatalLevel, zerolog.PanicLevel} {
+			logger.WithLevel(level).Msg(level.String()) // WithLevel doesn't exit or panic
+		}
+		logger.Log().Msg("no level")
+		Ω(logHook).Should(HaveLogs(
+			"trace", logrus.TraceLevel, "debug", logrus.DebugLevel,
+			"info", logrus.InfoLevel, "warn", logrus.WarnLevel,
+			"error", logrus.ErrorLevel, "fatal", logrus.FatalLevel,
+			"panic", logrus.PanicLevel, "no level", logrus.InfoLevel))
+	})
+	It("captures zerolog lines with their level and fields", func() {
+		_, err := io.WriteString(w, `{"level":"warn","host":"db1","port":5432,"ratio":0.5,"message":"slow query"}`+"\n")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(logHook).Should(HaveLogs("slow query", logrus.WarnLevel,
+			logrus.Fields{"host": "db1", "port": 5432, "ratio": 0.5}))
+	})
+	It("maps every zerolog level", func() {
+		for _, level := range []string{"trace", "debug", "info", "warn", "error", "fatal", "panic"} {
+			io.WriteString(w, `{"level":"`+level+`","message":"`+level+`"}`+"\n")
+		}
+		io.WriteString(w, `{"message":"no level"}`+"\n")
+		Ω(logHook).Should(HaveLogs(
+			"trace", logrus.TraceLevel, "debug", logrus.DebugLevel,
+			"info", logrus.InfoLevel, "warn", logrus.WarnLevel,
+			"error", logrus.ErrorLevel, "fatal", logrus.FatalLevel,
+			"panic", logrus.PanicLevel, "no level", logrus.InfoLevel))
+	})
+	It("decodes nested objects and arrays", func() {
+		io.WriteString(w, `{"level":"info","req":{"method":"GET","size":12},"tags":["a",2],"message":"handled"}`+"\n")
+		Ω(logHook).Should(HaveLogs("handled", logrus.Fields{
+			"req":  logrus.Fields{"method": "GET", "size": 12},
+			"tags": Equal([]interface{}{"a", 2}),
+		}))
+	})
+	It("takes the time and call site from the line", func() {
+		io.WriteString(w, `{"level":"info","time":"2021-03-04T05:06:07Z","caller":"/src/app/main.go:42","message":"started"}`+"\n")
+		records := logHook.EntriesWithSource()
+		Ω(records).Should(HaveLen(1))
+		Ω(records[0].Time).Should(BeTemporally("==", time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)))
+		Ω(records[0].Source).Should(Equal("/src/app/main.go:42"))
+		Ω(records[0].Fields).ShouldNot(HaveKey("caller"))
+		Ω(logHook).Should(HaveLogs("started"))
+	})
+	It("splits writes on newlines", func() {
+		io.WriteString(w, `{"message":"one"}`+"\n"+`{"mess`)
+		Ω(logHook.Count()).Should(Equal(1))
+		io.WriteString(w, `age":"two"}`+"\n")
+		Ω(logHook).Should(HaveLogsInOrder("one", "two"))
+	})
+	It("rejects lines that aren't JSON", func() {
+		_, err := io.WriteString(w, "5:06AM INF started\n")
+		Ω(err).Should(MatchError(ContainSubstring("not a zerolog JSON line")))
+	})
+})